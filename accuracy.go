@@ -0,0 +1,16 @@
+package main
+
+import "math"
+
+// The filter keeps the whole 64-bit hash of every item, split into quotient
+// and remainder, so a key that was never inserted is reported present when
+// its hash equals the hash of one of the n stored items. With uniform hashes
+// that happens with probability 1 - (1 - 2^-64)^n ≈ 1 - e^(-n/2^64), which
+// only depends on n, not on the size of the filter.
+const hashSpace = 1 << 64
+
+// EstimateFalsePositiveRate returns the probability that a lookup of a key
+// that was never inserted answers true, given the items currently stored.
+func (qf *QuotientFilter) EstimateFalsePositiveRate() float64 {
+	return -math.Expm1(-float64(qf.Count()) / hashSpace)
+}
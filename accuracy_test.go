@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestQuotientFilterEstimateFalsePositiveRate(t *testing.T) {
+	qf := NewQuotientFilter(10)
+	if rate := qf.EstimateFalsePositiveRate(); rate != 0 {
+		t.Errorf("Expected a rate of 0 for an empty filter, got %g", rate)
+	}
+
+	for i := 0; i < 100; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+	if rate, expected := qf.EstimateFalsePositiveRate(), 100/math.Pow(2, 64); math.Abs(rate-expected) > expected*1e-9 {
+		t.Errorf("Expected a rate of about %g with 100 items, got %g", expected, rate)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// AsyncInserts queues keys accepted by /v1/insert?async=true until they are
+// written to the filter.
+var AsyncInserts *AsyncInserter
+
+// AsyncInserter decouples accepting an insert from applying it. Keys are
+// held in a bounded in-memory queue and written by a background worker, so a
+// key acknowledged with 202 Accepted is lost if the process dies before the
+// worker reaches it, and may not be visible to Exists right away. Inserts
+// that fail once dequeued are recorded in deadLetters, if set.
+type AsyncInserter struct {
+	queue       chan []byte
+	deadLetters *DeadLetterLog
+	done        chan struct{}
+	mu          sync.RWMutex // Guards closed against Enqueue sending on a closed queue
+	closed      bool
+}
+
+func NewAsyncInserter(size int, deadLetters *DeadLetterLog) *AsyncInserter {
+	return &AsyncInserter{queue: make(chan []byte, size), deadLetters: deadLetters, done: make(chan struct{})}
+}
+
+// Enqueue queues key without blocking, and reports false when the queue is
+// full or the inserter is closed.
+func (a *AsyncInserter) Enqueue(key []byte) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return false
+	}
+
+	select {
+	case a.queue <- key:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run writes queued keys to qf until Close is called and the queue is
+// drained, or until ctx is done, which drops the keys still queued.
+func (a *AsyncInserter) Run(ctx context.Context, qf *QuotientFilter) {
+	defer close(a.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key, ok := <-a.queue:
+			if !ok {
+				return
+			}
+			a.apply(qf, key)
+		}
+	}
+}
+
+// Close stops accepting keys and waits for Run to apply the keys already
+// queued and return, so the dead-letter log can be closed afterwards. Run
+// must have been started.
+func (a *AsyncInserter) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+}
+
+func (a *AsyncInserter) apply(qf *QuotientFilter, key []byte) {
+	added, err := qf.InsertNew(key)
+	recordInsert(added, err)
+	if err != nil {
+		log.Printf("Error applying async insert: %s", err)
+		a.deadLetters.Record("insert", key, err)
+	}
+}
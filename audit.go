@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// auditBuffer bounds the entries waiting to be written, like
+// deadLetterBuffer.
+const auditBuffer = 1024
+
+// AuditEntry is one line of the audit log. Keys are never written as they
+// are, only as a salted SHA-256, so the log can prove that a key was written
+// by whoever knows the key and the salt without disclosing it. Ops applied by
+// fingerprint, where the key is unknown, carry a salted hash of the
+// fingerprint instead, and ops on the whole filter carry neither.
+type AuditEntry struct {
+	Time            time.Time `json:"time"`
+	Op              string    `json:"op"`
+	KeyHash         string    `json:"key_hash,omitempty"`
+	FingerprintHash string    `json:"fingerprint_hash,omitempty"`
+	NodeID          string    `json:"node_id"`
+}
+
+// AuditLog appends one in every rate applied writes to a file as JSON lines.
+// A filter records its writes itself once given a log with WithAuditLog, so
+// every path that changes it is covered. Like DeadLetterLog, entries are
+// written by a background goroutine and dropped rather than blocking the
+// caller when it falls behind. A nil *AuditLog records nothing.
+type AuditLog struct {
+	file    *os.File
+	rate    uint64
+	salt    []byte
+	nodeID  string
+	seen    atomic.Uint64
+	entries chan AuditEntry
+	done    chan struct{}
+	once    sync.Once
+}
+
+// OpenAuditLog opens, or creates, the audit log at path for appending. It
+// records one in every rate operations, every one of them when rate is 1 or
+// less.
+func OpenAuditLog(path string, rate int, salt, nodeID string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if rate < 1 {
+		rate = 1
+	}
+
+	al := &AuditLog{
+		file:    file,
+		rate:    uint64(rate),
+		salt:    []byte(salt),
+		nodeID:  nodeID,
+		entries: make(chan AuditEntry, auditBuffer),
+		done:    make(chan struct{}),
+	}
+	go al.run()
+	return al, nil
+}
+
+// WithAuditLog records the writes that change the filter in al: inserts of a
+// new fingerprint, removals, evictions, imports and resizes.
+func WithAuditLog(al *AuditLog) FilterOption {
+	return func(qf *QuotientFilter) {
+		qf.audit = al
+	}
+}
+
+// Record counts an op on key that was applied to the filter, and queues it
+// without blocking when it is sampled.
+func (al *AuditLog) Record(op string, key []byte) {
+	if al == nil || !al.sampled() {
+		return
+	}
+	al.queue(AuditEntry{Op: op, KeyHash: al.hashKey(key)})
+}
+
+// RecordFingerprint is Record for an op applied by fingerprint, such as an
+// eviction or a fingerprint insert, where the key is unknown.
+func (al *AuditLog) RecordFingerprint(op string, fp Fingerprint) {
+	if al == nil || !al.sampled() {
+		return
+	}
+
+	var encoded [16]byte
+	binary.BigEndian.PutUint64(encoded[:8], fp.Quotient)
+	binary.BigEndian.PutUint64(encoded[8:], fp.Remainder)
+	al.queue(AuditEntry{Op: op, FingerprintHash: al.hashKey(encoded[:])})
+}
+
+// RecordFilter records an op that replaces or rebuilds the whole filter,
+// such as an import or a resize. Those are rare, so they are never sampled
+// out.
+func (al *AuditLog) RecordFilter(op string) {
+	if al == nil {
+		return
+	}
+	al.queue(AuditEntry{Op: op})
+}
+
+func (al *AuditLog) sampled() bool {
+	return (al.seen.Add(1)-1)%al.rate == 0
+}
+
+func (al *AuditLog) queue(entry AuditEntry) {
+	entry.Time = time.Now().UTC()
+	entry.NodeID = al.nodeID
+	select {
+	case al.entries <- entry:
+	default:
+		log.Printf("Audit log is behind, dropping a sampled %s", entry.Op)
+	}
+}
+
+// Close writes the queued entries and closes the file. Nothing must be
+// recorded afterwards, so the filter must not change any more.
+func (al *AuditLog) Close() error {
+	al.once.Do(func() { close(al.entries) })
+	<-al.done
+	return al.file.Close()
+}
+
+func (al *AuditLog) hashKey(key []byte) string {
+	hash := sha256.New()
+	hash.Write(al.salt)
+	hash.Write(key)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (al *AuditLog) run() {
+	defer close(al.done)
+
+	encoder := json.NewEncoder(al.file)
+	for entry := range al.entries {
+		if err := encoder.Encode(entry); err != nil {
+			log.Printf("Error writing audit log: %s", err)
+		}
+	}
+}
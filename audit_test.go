@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestAuditLogSampling(t *testing.T) {
+	const operations = 40
+
+	for rate, expected := range map[int]int{1: operations, 4: operations / 4} {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		auditLog, err := OpenAuditLog(path, rate, "salt", "node-1")
+		if err != nil {
+			t.Fatalf("Failed to open audit log: %v", err)
+		}
+
+		qf := NewQuotientFilter(10, WithAuditLog(auditLog))
+		inserter := NewAsyncInserter(1, nil)
+		for i := 0; i < operations; i++ {
+			inserter.apply(qf, []byte(fmt.Sprintf("item%d", i)))
+		}
+		if err := auditLog.Close(); err != nil {
+			t.Fatalf("Failed to close audit log: %v", err)
+		}
+
+		entries := readAuditLog(t, path)
+		if len(entries) != expected {
+			t.Errorf("Rate %d: expected %d audit entries, got %d", rate, expected, len(entries))
+		}
+		for _, entry := range entries {
+			if entry.Op != "insert" || entry.NodeID != "node-1" || entry.Time.IsZero() || len(entry.KeyHash) != 64 {
+				t.Errorf("Rate %d: unexpected audit entry %+v", rate, entry)
+			}
+		}
+	}
+}
+
+func TestAuditLogHashesKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(path, 1, "salt", "node-1")
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	auditLog.Record("insert", []byte("secret"))
+	auditLog.Record("remove", []byte("secret"))
+	if err := auditLog.Close(); err != nil {
+		t.Fatalf("Failed to close audit log: %v", err)
+	}
+
+	entries := readAuditLog(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit entries, got %d", len(entries))
+	}
+	if entries[0].KeyHash != entries[1].KeyHash {
+		t.Errorf("Expected the same key to hash the same, got %s and %s", entries[0].KeyHash, entries[1].KeyHash)
+	}
+
+	unsalted := &AuditLog{}
+	if entries[0].KeyHash == unsalted.hashKey([]byte("secret")) {
+		t.Errorf("Expected the key hash to depend on the salt")
+	}
+}
+
+func TestAuditLogWriteEndpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(path, 1, "salt", "node-1")
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	useTestFilter(t, 8)
+	QF = NewKeyRetainingQuotientFilter(8, WithAuditLog(auditLog))
+	requests := []struct {
+		handler fasthttp.RequestHandler
+		uri     string
+		body    string
+	}{
+		{v1TestAndInsertHandler, "/v1/test_and_insert", `{"key":"user:1"}`},
+		// Claiming a stored key changes nothing.
+		{v1TestAndInsertHandler, "/v1/test_and_insert", `{"key":"user:1"}`},
+		{v1InsertHandler, "/v1/insert", `{"key":"user:2"}`},
+		{v1InsertHandler, "/v1/insert", `{"key":"other"}`},
+		{v1RemovePrefixHandler, "/v1/remove_prefix", `{"prefix":"user:"}`},
+	}
+	for _, r := range requests {
+		ctx := newTestRequestCtx("POST", r.uri, []byte(r.body))
+		r.handler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", r.uri, r.body, fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+	}
+
+	QF = NewQuotientFilter(8, WithAuditLog(auditLog))
+	for i := 0; i < 2; i++ {
+		// The second request inserts a fingerprint already stored.
+		ctx := newTestRequestCtx("POST", "/v1/insert_fingerprints", []byte(`{"fingerprints":[{"q":3,"r":7}]}`))
+		v1InsertFingerprintsHandler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+	}
+
+	if err := auditLog.Close(); err != nil {
+		t.Fatalf("Failed to close audit log: %v", err)
+	}
+
+	entries := readAuditLog(t, path)
+	expected := []string{"insert", "insert", "insert", "remove", "remove", "insert_fingerprint"}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d audit entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		if entry.Op != expected[i] {
+			t.Errorf("Entry %d: expected op %s, got %+v", i, expected[i], entry)
+		}
+	}
+	if entry := entries[len(entries)-1]; entry.KeyHash != "" || len(entry.FingerprintHash) != 64 {
+		t.Errorf("Expected a fingerprint insert to carry a fingerprint hash only, got %+v", entry)
+	}
+}
+
+func TestAuditLogFilterWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(path, 1, "salt", "node-1")
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	qf := NewQuotientFilter(4, WithFIFOEviction(), WithAuditLog(auditLog))
+	for i := 0; i <= qf.Capacity(); i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+	if err := qf.Resize(5); err != nil {
+		t.Fatalf("Failed to resize: %v", err)
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatalf("Failed to close audit log: %v", err)
+	}
+
+	ops := make(map[string]int)
+	for _, entry := range readAuditLog(t, path) {
+		ops[entry.Op]++
+	}
+	if ops["insert"] != qf.Capacity()/2+1 || ops["evict"] != 1 || ops["resize"] != 1 {
+		t.Errorf("Expected %d inserts, 1 eviction and 1 resize, got %v", qf.Capacity()/2+1, ops)
+	}
+}
+
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to decode audit entry %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
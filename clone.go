@@ -0,0 +1,35 @@
+package main
+
+import "sync/atomic"
+
+// Clone returns an independent copy of the filter, taken under all stripe
+// read locks, so expensive analysis such as RunLengthStats or
+// EstimateCardinality can run on the copy without holding up the live
+// filter. The copy keeps the hash settings, stripe count, retained keys and
+// insertion order, lives in memory even when the original is memory-mapped,
+// starts with empty stripe stats and negative cache, and records nothing to
+// the audit log.
+func (qf *QuotientFilter) Clone() *QuotientFilter {
+	qf.rLockAll()
+	defer qf.rUnlockAll()
+
+	clone := newQuotientFilter(make([]uint64, len(qf.data)), qf.quotient, []FilterOption{
+		WithHashAlgorithm(qf.algorithm),
+		WithHashSeed(qf.seed),
+		WithKeyNormalizer(qf.normalizer),
+		WithMaxWalk(qf.maxWalk),
+		WithStripeCount(qf.StripeCount()),
+	})
+	for slot := range qf.data {
+		clone.data[slot] = atomic.LoadUint64(&qf.data[slot])
+	}
+	clone.count.Store(qf.count.Load())
+
+	if qf.keys != nil {
+		clone.keys = qf.keys.clone()
+	}
+	if qf.fifo != nil {
+		clone.fifo = qf.fifo.clone()
+	}
+	return clone
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQuotientFilterClone(t *testing.T) {
+	original := NewKeyRetainingQuotientFilter(8, WithHashAlgorithm(HashFNV))
+	for i := 0; i < 100; i++ {
+		original.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+	checksum := original.Checksum()
+
+	clone := original.Clone()
+	if clone.Count() != original.Count() || clone.Checksum() != checksum {
+		t.Fatalf("Expected the clone to hold the same items, got count %d", clone.Count())
+	}
+	if clone.HashAlgorithm() != HashFNV {
+		t.Errorf("Expected the clone to keep the hash algorithm, got %q", clone.HashAlgorithm())
+	}
+
+	clone.Remove([]byte("item0"))
+	clone.Insert([]byte("clone-only"))
+
+	if original.Count() != 100 || original.Checksum() != checksum {
+		t.Errorf("Mutating the clone changed the original: count %d", original.Count())
+	}
+	if exists, _ := original.Exists([]byte("clone-only")); exists {
+		t.Error("clone-only should not exist in the original")
+	}
+	if exists, _ := original.Exists([]byte("item0")); !exists {
+		t.Error("item0 should still exist in the original")
+	}
+	if len(original.Keys()) != 100 {
+		t.Errorf("Expected the original to keep 100 keys, got %d", len(original.Keys()))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// lockCluster takes the stripe locks of every slot an operation on hashValue
+// may read or move: the cluster holding its quotient, and the empty slot
+// right after it, which an insert may shift entries into. Inserts and
+// removals shift entries across slots owned by other stripes, so the stripe
+// of the quotient alone would let writers of neighbouring quotients corrupt a
+// shared cluster. The cluster is measured before its stripes are held and
+// may grow meanwhile, so it is measured again once they are, until the held
+// stripes cover it. Stripes are always taken in ascending order, like
+// lockAll, so that operations cannot deadlock each other. The returned
+// function releases every lock taken.
+func (qf *QuotientFilter) lockCluster(ctx context.Context, hashValue uint64, write bool) (func(), error) {
+	held := []int{qf.stripe(hashValue)}
+	for {
+		if err := qf.lockStripes(ctx, held, write); err != nil {
+			return nil, err
+		}
+
+		needed := qf.clusterStripes(hashValue, held)
+		if len(needed) == len(held) {
+			return func() { qf.unlockStripes(held, write) }, nil
+		}
+
+		qf.unlockStripes(held, write)
+		held = needed
+	}
+}
+
+// clusterStripes returns, in ascending order, the stripes in held along with
+// the stripes owning the cluster of hashValue's quotient and the empty slot
+// that ends it. The result can only be trusted when it adds no stripe to
+// held, since the slots of the other stripes may be changing under it.
+func (qf *QuotientFilter) clusterStripes(hashValue uint64, held []int) []int {
+	quotient, _ := qf.split(hashValue)
+	slots := qf.mask + 1
+
+	start := quotient
+	for walked := uint64(1); qf.isShifted(start) && walked < slots; walked++ {
+		start = (start - 1) & qf.mask
+	}
+	length := uint64(1)
+	for slot := start; !qf.isEmpty(slot) && length < slots; slot = (slot + 1) & qf.mask {
+		length++
+	}
+
+	if length >= uint64(len(qf.locks)) {
+		all := make([]int, len(qf.locks))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	needed := append(make([]int, 0, len(held)+int(length)), held...)
+	for i := uint64(0); i < length; i++ {
+		needed = append(needed, qf.stripe((start+i)&qf.mask))
+	}
+	sort.Ints(needed)
+
+	unique := needed[:1]
+	for _, stripe := range needed[1:] {
+		if stripe != unique[len(unique)-1] {
+			unique = append(unique, stripe)
+		}
+	}
+	return unique
+}
+
+// lockStripes takes the locks of stripes, which must be in ascending order.
+// On error, the locks already taken are released.
+func (qf *QuotientFilter) lockStripes(ctx context.Context, stripes []int, write bool) error {
+	for i, stripe := range stripes {
+		var err error
+		if write {
+			err = qf.lockStripeContext(ctx, uint64(stripe))
+		} else {
+			err = qf.rLockStripeContext(ctx, uint64(stripe))
+		}
+		if err != nil {
+			qf.unlockStripes(stripes[:i], write)
+			return err
+		}
+	}
+	return nil
+}
+
+func (qf *QuotientFilter) unlockStripes(stripes []int, write bool) {
+	for _, stripe := range stripes {
+		if write {
+			qf.unlockStripe(uint64(stripe))
+		} else {
+			qf.rUnlockStripe(uint64(stripe))
+		}
+	}
+}
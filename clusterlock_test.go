@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestQuotientFilterConcurrentChurn(t *testing.T) {
+	qf := NewQuotientFilter(10)
+
+	const workers, keysPerWorker, operations = 8, 100, 20000
+	live := make([]map[string]bool, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		live[w] = make(map[string]bool)
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < operations; i++ {
+				key := fmt.Sprintf("worker%d-key%d", w, rng.Intn(keysPerWorker))
+				if rng.Intn(3) == 0 {
+					qf.Remove([]byte(key))
+					delete(live[w], key)
+				} else {
+					if err := qf.Insert([]byte(key)); err != nil {
+						t.Errorf("Failed to insert %s: %v", key, err)
+						return
+					}
+					live[w][key] = true
+				}
+				qf.Exists([]byte(key))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	total := 0
+	for w := range live {
+		total += len(live[w])
+		for key := range live[w] {
+			if exists, _ := qf.Exists([]byte(key)); !exists {
+				t.Errorf("Expected live key %s to exist", key)
+			}
+		}
+	}
+	if qf.Count() != total {
+		t.Errorf("Expected count %d, got %d", total, qf.Count())
+	}
+	if err := qf.Verify(); err != nil {
+		t.Errorf("Filter is corrupted: %v", err)
+	}
+}
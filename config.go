@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -11,14 +13,42 @@ import (
 
 type Config struct {
 	Quotient struct {
-		LogSize uint `yaml:"logSize"`
+		LogSize           uint          `yaml:"logSize"`
+		VerifyInterval    time.Duration `yaml:"verifyInterval"`
+		MmapPath          string        `yaml:"mmapPath"`
+		RetainKeys        bool          `yaml:"retainKeys"`
+		Warmup            bool          `yaml:"warmup"`
+		HashAlgorithm     string        `yaml:"hashAlgorithm"`
+		HashSeed          uint32        `yaml:"hashSeed"`
+		MaxMemoryFraction float64       `yaml:"maxMemoryFraction"`
+		StripeStats       bool          `yaml:"stripeStats"`
+		NegativeCacheSize int           `yaml:"negativeCacheSize"`
+		MaxWalk           int           `yaml:"maxWalk"`
+		KeyNormalization  []string      `yaml:"keyNormalization"`
+		Stripes           uint          `yaml:"stripes"`
+		AutoStripes       bool          `yaml:"autoStripes"`
+		FIFOEviction      bool          `yaml:"fifoEviction"`
+		SelfTest          bool          `yaml:"selfTest"`
 	}
 
 	Server struct {
-		Host        string `yaml:"host"`
-		Port        int    `yaml:"port"`
-		Concurrency int    `yaml:"concurrency"`
-		APIKey      string `yaml:"api_key"`
+		Host               string `yaml:"host"`
+		Port               int    `yaml:"port"`
+		Concurrency        int    `yaml:"concurrency"`
+		APIKey             string `yaml:"api_key"`
+		Debug              bool   `yaml:"debug"`
+		AsyncQueueSize     int    `yaml:"async_queue_size"`
+		MaxBodySize        int    `yaml:"max_body_size"`
+		AccessLog          bool   `yaml:"access_log"`
+		DeadLetterPath     string `yaml:"dead_letter_path"`
+		Compression        bool   `yaml:"compression"`
+		CompressionMinSize int    `yaml:"compression_min_size"`
+		UnixSocket         string `yaml:"unix_socket"`
+		UnixSocketMode     string `yaml:"unix_socket_mode"`
+		DisableTCP         bool   `yaml:"disable_tcp"`
+		AuditLogPath       string `yaml:"audit_log_path"`
+		AuditSampleRate    int    `yaml:"audit_sample_rate"`
+		AuditSalt          string `yaml:"audit_salt"`
 	} `yaml:"server"`
 
 	Raft struct {
@@ -28,35 +58,96 @@ type Config struct {
 		SnapshotDir string        `yaml:"snapshot_dir"`
 		LogDir      string        `yaml:"log_dir"`
 	} `yaml:"raft"`
+
+	Metrics struct {
+		ExistsLatencyBuckets []time.Duration `yaml:"exists_latency_buckets"`
+		LogInterval          time.Duration   `yaml:"log_interval"`
+	} `yaml:"metrics"`
 }
 
 const (
-	DefaultConfigFilename = "quotient.config.yaml"
-	defaultServerPort     = 8080
-	defaultAPIKey         = "xyz"
-	defaultSnapshotDir    = "/quotient/raft/snapshots"
-	defaultLogDir         = "/quotient/raft/logs"
-	defaultLogSize        = 22
+	DefaultConfigFilename     = "quotient.config.yaml"
+	defaultServerPort         = 8080
+	defaultAPIKey             = "xyz"
+	defaultSnapshotDir        = "/quotient/raft/snapshots"
+	defaultLogDir             = "/quotient/raft/logs"
+	defaultLogSize            = 22
+	defaultMemoryFraction     = 0.8
+	defaultAsyncQueueSize     = 4096
+	defaultMaxBodySize        = 1 << 20
+	defaultCompressionMinSize = 1024
+	defaultUnixSocketMode     = "0660"
 )
 
+var defaultExistsLatencyBuckets = []time.Duration{
+	250 * time.Nanosecond,
+	500 * time.Nanosecond,
+	time.Microsecond,
+	2500 * time.Nanosecond,
+	5 * time.Microsecond,
+	10 * time.Microsecond,
+	25 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+}
+
 func createDefaultConfig() *Config {
 	return &Config{
 		Quotient: struct {
-			LogSize uint `yaml:"logSize"`
+			LogSize           uint          `yaml:"logSize"`
+			VerifyInterval    time.Duration `yaml:"verifyInterval"`
+			MmapPath          string        `yaml:"mmapPath"`
+			RetainKeys        bool          `yaml:"retainKeys"`
+			Warmup            bool          `yaml:"warmup"`
+			HashAlgorithm     string        `yaml:"hashAlgorithm"`
+			HashSeed          uint32        `yaml:"hashSeed"`
+			MaxMemoryFraction float64       `yaml:"maxMemoryFraction"`
+			StripeStats       bool          `yaml:"stripeStats"`
+			NegativeCacheSize int           `yaml:"negativeCacheSize"`
+			MaxWalk           int           `yaml:"maxWalk"`
+			KeyNormalization  []string      `yaml:"keyNormalization"`
+			Stripes           uint          `yaml:"stripes"`
+			AutoStripes       bool          `yaml:"autoStripes"`
+			FIFOEviction      bool          `yaml:"fifoEviction"`
+			SelfTest          bool          `yaml:"selfTest"`
 		}{
-			LogSize: defaultLogSize,
+			LogSize:           defaultLogSize,
+			HashAlgorithm:     string(DefaultHashAlgorithm),
+			MaxMemoryFraction: defaultMemoryFraction,
 		},
 
 		Server: struct {
-			Host        string `yaml:"host"`
-			Port        int    `yaml:"port"`
-			Concurrency int    `yaml:"concurrency"`
-			APIKey      string `yaml:"api_key"`
+			Host               string `yaml:"host"`
+			Port               int    `yaml:"port"`
+			Concurrency        int    `yaml:"concurrency"`
+			APIKey             string `yaml:"api_key"`
+			Debug              bool   `yaml:"debug"`
+			AsyncQueueSize     int    `yaml:"async_queue_size"`
+			MaxBodySize        int    `yaml:"max_body_size"`
+			AccessLog          bool   `yaml:"access_log"`
+			DeadLetterPath     string `yaml:"dead_letter_path"`
+			Compression        bool   `yaml:"compression"`
+			CompressionMinSize int    `yaml:"compression_min_size"`
+			UnixSocket         string `yaml:"unix_socket"`
+			UnixSocketMode     string `yaml:"unix_socket_mode"`
+			DisableTCP         bool   `yaml:"disable_tcp"`
+			AuditLogPath       string `yaml:"audit_log_path"`
+			AuditSampleRate    int    `yaml:"audit_sample_rate"`
+			AuditSalt          string `yaml:"audit_salt"`
 		}{
-			Host:        "localhost",
-			Port:        defaultServerPort,
-			Concurrency: runtime.NumCPU(),
-			APIKey:      defaultAPIKey,
+			Host:               "localhost",
+			Port:               defaultServerPort,
+			Concurrency:        runtime.NumCPU(),
+			APIKey:             defaultAPIKey,
+			AsyncQueueSize:     defaultAsyncQueueSize,
+			MaxBodySize:        defaultMaxBodySize,
+			CompressionMinSize: defaultCompressionMinSize,
+			UnixSocketMode:     defaultUnixSocketMode,
 		},
 
 		Raft: struct {
@@ -72,6 +163,13 @@ func createDefaultConfig() *Config {
 			SnapshotDir: defaultSnapshotDir,
 			LogDir:      defaultLogDir,
 		},
+
+		Metrics: struct {
+			ExistsLatencyBuckets []time.Duration `yaml:"exists_latency_buckets"`
+			LogInterval          time.Duration   `yaml:"log_interval"`
+		}{
+			ExistsLatencyBuckets: defaultExistsLatencyBuckets,
+		},
 	}
 }
 
@@ -81,6 +179,51 @@ func mergeConfigs(defaultConfig, userConfig Config) Config {
 	if userConfig.Quotient.LogSize > 0 {
 		mergedConfig.Quotient.LogSize = userConfig.Quotient.LogSize
 	}
+	if userConfig.Quotient.VerifyInterval > 0 {
+		mergedConfig.Quotient.VerifyInterval = userConfig.Quotient.VerifyInterval
+	}
+	if userConfig.Quotient.MmapPath != "" {
+		mergedConfig.Quotient.MmapPath = userConfig.Quotient.MmapPath
+	}
+	if userConfig.Quotient.RetainKeys {
+		mergedConfig.Quotient.RetainKeys = true
+	}
+	if userConfig.Quotient.Warmup {
+		mergedConfig.Quotient.Warmup = true
+	}
+	if userConfig.Quotient.HashAlgorithm != "" {
+		mergedConfig.Quotient.HashAlgorithm = userConfig.Quotient.HashAlgorithm
+	}
+	if userConfig.Quotient.HashSeed != 0 {
+		mergedConfig.Quotient.HashSeed = userConfig.Quotient.HashSeed
+	}
+	if userConfig.Quotient.MaxMemoryFraction > 0 {
+		mergedConfig.Quotient.MaxMemoryFraction = userConfig.Quotient.MaxMemoryFraction
+	}
+	if userConfig.Quotient.StripeStats {
+		mergedConfig.Quotient.StripeStats = true
+	}
+	if userConfig.Quotient.NegativeCacheSize > 0 {
+		mergedConfig.Quotient.NegativeCacheSize = userConfig.Quotient.NegativeCacheSize
+	}
+	if userConfig.Quotient.MaxWalk > 0 {
+		mergedConfig.Quotient.MaxWalk = userConfig.Quotient.MaxWalk
+	}
+	if len(userConfig.Quotient.KeyNormalization) > 0 {
+		mergedConfig.Quotient.KeyNormalization = userConfig.Quotient.KeyNormalization
+	}
+	if userConfig.Quotient.Stripes > 0 {
+		mergedConfig.Quotient.Stripes = userConfig.Quotient.Stripes
+	}
+	if userConfig.Quotient.AutoStripes {
+		mergedConfig.Quotient.AutoStripes = true
+	}
+	if userConfig.Quotient.FIFOEviction {
+		mergedConfig.Quotient.FIFOEviction = true
+	}
+	if userConfig.Quotient.SelfTest {
+		mergedConfig.Quotient.SelfTest = true
+	}
 	if userConfig.Server.Port != 0 {
 		mergedConfig.Server.Port = userConfig.Server.Port
 	}
@@ -90,6 +233,45 @@ func mergeConfigs(defaultConfig, userConfig Config) Config {
 	if userConfig.Server.APIKey != "" {
 		mergedConfig.Server.APIKey = userConfig.Server.APIKey
 	}
+	if userConfig.Server.Debug {
+		mergedConfig.Server.Debug = true
+	}
+	if userConfig.Server.AsyncQueueSize > 0 {
+		mergedConfig.Server.AsyncQueueSize = userConfig.Server.AsyncQueueSize
+	}
+	if userConfig.Server.MaxBodySize > 0 {
+		mergedConfig.Server.MaxBodySize = userConfig.Server.MaxBodySize
+	}
+	if userConfig.Server.AccessLog {
+		mergedConfig.Server.AccessLog = true
+	}
+	if userConfig.Server.DeadLetterPath != "" {
+		mergedConfig.Server.DeadLetterPath = userConfig.Server.DeadLetterPath
+	}
+	if userConfig.Server.Compression {
+		mergedConfig.Server.Compression = true
+	}
+	if userConfig.Server.CompressionMinSize > 0 {
+		mergedConfig.Server.CompressionMinSize = userConfig.Server.CompressionMinSize
+	}
+	if userConfig.Server.UnixSocket != "" {
+		mergedConfig.Server.UnixSocket = userConfig.Server.UnixSocket
+	}
+	if userConfig.Server.UnixSocketMode != "" {
+		mergedConfig.Server.UnixSocketMode = userConfig.Server.UnixSocketMode
+	}
+	if userConfig.Server.DisableTCP {
+		mergedConfig.Server.DisableTCP = true
+	}
+	if userConfig.Server.AuditLogPath != "" {
+		mergedConfig.Server.AuditLogPath = userConfig.Server.AuditLogPath
+	}
+	if userConfig.Server.AuditSampleRate != 0 {
+		mergedConfig.Server.AuditSampleRate = userConfig.Server.AuditSampleRate
+	}
+	if userConfig.Server.AuditSalt != "" {
+		mergedConfig.Server.AuditSalt = userConfig.Server.AuditSalt
+	}
 	if userConfig.Raft.NodeID != "" {
 		mergedConfig.Raft.NodeID = userConfig.Raft.NodeID
 	}
@@ -105,12 +287,18 @@ func mergeConfigs(defaultConfig, userConfig Config) Config {
 	if userConfig.Raft.LogDir != "" {
 		mergedConfig.Raft.LogDir = userConfig.Raft.LogDir
 	}
+	if len(userConfig.Metrics.ExistsLatencyBuckets) > 0 {
+		mergedConfig.Metrics.ExistsLatencyBuckets = userConfig.Metrics.ExistsLatencyBuckets
+	}
+	if userConfig.Metrics.LogInterval > 0 {
+		mergedConfig.Metrics.LogInterval = userConfig.Metrics.LogInterval
+	}
 
 	return mergedConfig
 }
 
-func ParseConfigFile() (*Config, error) {
-	file, err := os.Open(DefaultConfigFilename)
+func ParseConfigFile(path string) (*Config, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("could not open config file: %w", err)
 	}
@@ -127,3 +315,77 @@ func ParseConfigFile() (*Config, error) {
 
 	return &finalConfig, nil
 }
+
+// Validate reports every setting of c that would make the server fail to
+// start or silently misbehave, joined into a single error.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if err := checkLogSize(c.Quotient.LogSize); err != nil {
+		errs = append(errs, fmt.Errorf("quotient.%w", err))
+	}
+	if _, err := ParseHashAlgorithm(c.Quotient.HashAlgorithm); err != nil {
+		errs = append(errs, fmt.Errorf("quotient.hashAlgorithm: %w", err))
+	}
+	if _, err := ParseKeyNormalizer(c.Quotient.KeyNormalization); err != nil {
+		errs = append(errs, fmt.Errorf("quotient.keyNormalization: %w", err))
+	}
+	if c.Quotient.MaxMemoryFraction > 1 {
+		errs = append(errs, fmt.Errorf("quotient.maxMemoryFraction %g must be at most 1", c.Quotient.MaxMemoryFraction))
+	}
+	if c.Quotient.Stripes > maxStripes {
+		errs = append(errs, fmt.Errorf("quotient.stripes %d is too large, the maximum is %d", c.Quotient.Stripes, maxStripes))
+	}
+	if c.Quotient.RetainKeys && c.Quotient.MmapPath != "" {
+		errs = append(errs, errors.New("quotient.retainKeys cannot be combined with quotient.mmapPath"))
+	}
+	if c.Quotient.FIFOEviction && c.Quotient.MmapPath != "" {
+		errs = append(errs, errors.New("quotient.fifoEviction cannot be combined with quotient.mmapPath"))
+	}
+	if _, err := parseFileMode(c.Server.UnixSocketMode); err != nil {
+		errs = append(errs, fmt.Errorf("server.unix_socket_mode: %w", err))
+	}
+	if c.Server.DisableTCP && c.Server.UnixSocket == "" {
+		errs = append(errs, errors.New("server.disable_tcp requires server.unix_socket, or there is nothing to listen on"))
+	}
+	if c.Server.AuditSampleRate < 0 {
+		errs = append(errs, fmt.Errorf("server.audit_sample_rate %d must not be negative", c.Server.AuditSampleRate))
+	}
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %d is out of range", c.Server.Port))
+	}
+	if c.Server.Concurrency < 0 {
+		errs = append(errs, fmt.Errorf("server.concurrency %d must not be negative", c.Server.Concurrency))
+	}
+	for _, bucket := range c.Metrics.ExistsLatencyBuckets {
+		if bucket <= 0 {
+			errs = append(errs, fmt.Errorf("metrics.exists_latency_buckets: bucket %s must be positive", bucket))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseFileMode parses an octal permission string such as "0660".
+func parseFileMode(mode string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || bits > 0o777 {
+		return 0, fmt.Errorf("invalid file mode %q, expected octal permissions such as \"0660\"", mode)
+	}
+	return os.FileMode(bits), nil
+}
+
+// redactedValue replaces secrets in Redacted configs.
+const redactedValue = "[redacted]"
+
+// Redacted returns a copy of c that is safe to expose, with secrets replaced
+// by a placeholder.
+func (c Config) Redacted() Config {
+	if c.Server.APIKey != "" {
+		c.Server.APIKey = redactedValue
+	}
+	if c.Server.AuditSalt != "" {
+		c.Server.AuditSalt = redactedValue
+	}
+	return c
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadLetterBuffer bounds the entries waiting to be written, so a slow disk
+// never holds up the caller recording them.
+const deadLetterBuffer = 1024
+
+// DeadLetter is one line of the dead-letter log: an operation that could not
+// be applied after it was acknowledged, with enough detail to replay it.
+type DeadLetter struct {
+	Time  time.Time `json:"time"`
+	Op    string    `json:"op"`
+	Key   string    `json:"key"`
+	Error string    `json:"error"`
+}
+
+// DeadLetterLog appends failed operations to a file as JSON lines. Entries
+// are written by a background goroutine; when it falls too far behind, new
+// entries are dropped and logged instead of blocking the caller. A nil
+// *DeadLetterLog records nothing.
+type DeadLetterLog struct {
+	file    *os.File
+	entries chan DeadLetter
+	done    chan struct{}
+	once    sync.Once
+}
+
+// OpenDeadLetterLog opens, or creates, the dead-letter log at path for
+// appending.
+func OpenDeadLetterLog(path string) (*DeadLetterLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	dl := &DeadLetterLog{
+		file:    file,
+		entries: make(chan DeadLetter, deadLetterBuffer),
+		done:    make(chan struct{}),
+	}
+	go dl.run()
+	return dl, nil
+}
+
+// Record queues a failed op on key without blocking.
+func (dl *DeadLetterLog) Record(op string, key []byte, err error) {
+	if dl == nil {
+		return
+	}
+
+	entry := DeadLetter{Time: time.Now().UTC(), Op: op, Key: string(key), Error: err.Error()}
+	select {
+	case dl.entries <- entry:
+	default:
+		log.Printf("Dead-letter log is behind, dropping %s of %q: %s", op, key, err)
+	}
+}
+
+// Close writes the queued entries and closes the file. Record must not be
+// called afterwards.
+func (dl *DeadLetterLog) Close() error {
+	dl.once.Do(func() { close(dl.entries) })
+	<-dl.done
+	return dl.file.Close()
+}
+
+func (dl *DeadLetterLog) run() {
+	defer close(dl.done)
+
+	encoder := json.NewEncoder(dl.file)
+	for entry := range dl.entries {
+		if err := encoder.Encode(entry); err != nil {
+			log.Printf("Error writing dead-letter log: %s", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAsyncInsertDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	deadLetters, err := OpenDeadLetterLog(path)
+	if err != nil {
+		t.Fatalf("Failed to open dead-letter log: %v", err)
+	}
+
+	qf := NewQuotientFilter(4)
+	for i := 0; qf.Count() < qf.Capacity(); i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+
+	inserter := NewAsyncInserter(1, deadLetters)
+	inserter.apply(qf, []byte("overflow"))
+	if err := deadLetters.Close(); err != nil {
+		t.Fatalf("Failed to close dead-letter log: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open dead-letter log: %v", err)
+	}
+	defer file.Close()
+
+	var entries []DeadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to decode dead letter %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Op != "insert" || entry.Key != "overflow" || entry.Error != ErrFilterFull.Error() || entry.Time.IsZero() {
+		t.Errorf("Unexpected dead letter %+v", entry)
+	}
+}
+
+func TestAsyncInserterCloseDrainsQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	deadLetters, err := OpenDeadLetterLog(path)
+	if err != nil {
+		t.Fatalf("Failed to open dead-letter log: %v", err)
+	}
+
+	qf := NewQuotientFilter(4)
+	for i := 0; qf.Count() < qf.Capacity()-1; i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+
+	// Both keys are queued before the worker starts: the first one fills the
+	// filter, the second one fails while the server is shutting down.
+	inserter := NewAsyncInserter(2, deadLetters)
+	if !inserter.Enqueue([]byte("last")) || !inserter.Enqueue([]byte("overflow")) {
+		t.Fatal("Expected both keys to be queued")
+	}
+	go inserter.Run(context.Background(), qf)
+
+	inserter.Close()
+	if inserter.Enqueue([]byte("late")) {
+		t.Error("Expected a closed inserter to refuse keys")
+	}
+	if err := deadLetters.Close(); err != nil {
+		t.Fatalf("Failed to close dead-letter log: %v", err)
+	}
+
+	if exists, _ := qf.Exists([]byte("last")); !exists {
+		t.Error("Expected the queued key to be applied before Close returned")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dead-letter log: %v", err)
+	}
+	var entry DeadLetter
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Failed to decode dead letter %q: %v", data, err)
+	}
+	if entry.Key != "overflow" || entry.Error != ErrFilterFull.Error() {
+		t.Errorf("Unexpected dead letter %+v", entry)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/valyala/fasthttp"
+)
+
+// draining is set once the server stops accepting writes ahead of a
+// shutdown.
+var draining atomic.Bool
+
+var errDraining = errors.New("server is draining")
+
+// writeEndpoints are the paths rejected while draining. Reads keep being
+// served until the server stops.
+var writeEndpoints = map[string]bool{
+	"/v1/insert":              true,
+	"/v1/test_and_insert":     true,
+	"/v1/insert_fingerprints": true,
+	"/v1/remove":              true,
+	"/v1/remove_prefix":       true,
+	"/v1/resize":              true,
+	"/v1/import":              true,
+}
+
+// withDrain answers writes with 503 Service Unavailable once the server is
+// draining, so clients fail fast and retry against another server.
+func withDrain(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if draining.Load() && writeEndpoints[string(ctx.Path())] {
+			errorResponse(ctx, fasthttp.StatusServiceUnavailable, "DRAINING", errDraining)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// v1DrainHandler puts the server in drain mode. There is no way back short
+// of a restart.
+func v1DrainHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	if !draining.Swap(true) {
+		log.Println("Draining: rejecting new writes")
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody([]byte("Draining"))
+}
+
+// shutdownOnSignal drains the server on SIGINT or SIGTERM, then shuts it
+// down once the requests in flight are answered.
+func shutdownOnSignal(server *fasthttp.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	draining.Store(true)
+	log.Printf("Received %s, draining and shutting down", sig)
+	if err := server.Shutdown(); err != nil {
+		log.Printf("Error shutting down server: %s", err)
+	}
+}
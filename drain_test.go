@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDrain(t *testing.T) {
+	useTestFilter(t, 8)
+	t.Cleanup(func() { draining.Store(false) })
+	handler := newRequestHandler(Configuration)
+
+	insert := func() int {
+		ctx := newTestRequestCtx("POST", "/v1/insert", []byte(`{"key":"alpha"}`))
+		handler(ctx)
+		return ctx.Response.StatusCode()
+	}
+	if status := insert(); status != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d before draining, got %d", fasthttp.StatusOK, status)
+	}
+
+	drainCtx := newTestRequestCtx("POST", "/v1/drain", nil)
+	handler(drainCtx)
+	if drainCtx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fasthttp.StatusOK, drainCtx.Response.StatusCode())
+	}
+
+	if status := insert(); status != fasthttp.StatusServiceUnavailable {
+		t.Errorf("Expected status %d while draining, got %d", fasthttp.StatusServiceUnavailable, status)
+	}
+
+	existsCtx := newTestRequestCtx("GET", "/v1/exists?key=alpha", nil)
+	handler(existsCtx)
+	if existsCtx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected lookups to keep working while draining, got status %d", existsCtx.Response.StatusCode())
+	}
+	var response V1ExistsResponse
+	if err := json.Unmarshal(existsCtx.Response.Body(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !response.Exists {
+		t.Error("Expected the key inserted before draining to exist")
+	}
+}
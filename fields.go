@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// fieldsKey frames an ordered list of fields into a single key. Each field is
+// normalized on its own and prefixed with its length as a uvarint, so that
+// two different lists never frame to the same key: ("a", "bc") and
+// ("ab", "c") differ in their first length, unlike their concatenations.
+func (qf *QuotientFilter) fieldsKey(fields [][]byte) []byte {
+	var key []byte
+	for _, field := range fields {
+		field = qf.normalize(field)
+		key = binary.AppendUvarint(key, uint64(len(field)))
+		key = append(key, field...)
+	}
+	return key
+}
+
+// InsertFields inserts a composite key made of fields, such as a (tenant, id)
+// pair, without the delimiter collisions of concatenating them. The order of
+// the fields matters. Key-retaining filters keep the framed key.
+func (qf *QuotientFilter) InsertFields(fields [][]byte) error {
+	_, err := qf.insert(context.Background(), qf.fieldsKey(fields))
+	return err
+}
+
+// ExistsFields looks up a composite key stored with InsertFields.
+func (qf *QuotientFilter) ExistsFields(fields [][]byte) (bool, time.Duration) {
+	exists, _, elapsed, _ := qf.exists(context.Background(), qf.fieldsKey(fields), time.Now())
+	return exists, elapsed
+}
+
+// RemoveFields removes a composite key stored with InsertFields.
+func (qf *QuotientFilter) RemoveFields(fields [][]byte) bool {
+	removed, _ := qf.remove(context.Background(), qf.fieldsKey(fields))
+	return removed
+}
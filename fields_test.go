@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestQuotientFilterFields(t *testing.T) {
+	qf := NewQuotientFilter(10)
+
+	first := [][]byte{[]byte("a"), []byte("bc")}
+	second := [][]byte{[]byte("ab"), []byte("c")}
+	if qf.hashKey(qf.fieldsKey(first)) == qf.hashKey(qf.fieldsKey(second)) {
+		t.Fatal("Expected (a, bc) and (ab, c) to have different fingerprints")
+	}
+
+	if err := qf.InsertFields(first); err != nil {
+		t.Fatalf("Failed to insert fields: %v", err)
+	}
+	if exists, _ := qf.ExistsFields(first); !exists {
+		t.Error("Expected (a, bc) to exist")
+	}
+	if exists, _ := qf.ExistsFields(second); exists {
+		t.Error("Expected (ab, c) not to exist")
+	}
+	if exists, _ := qf.Exists([]byte("abc")); exists {
+		t.Error("Expected the concatenated key not to exist")
+	}
+
+	if !qf.RemoveFields(first) {
+		t.Error("Expected (a, bc) to be removed")
+	}
+	if qf.Count() != 0 {
+		t.Errorf("Expected an empty filter, but found %d items", qf.Count())
+	}
+}
+
+func TestQuotientFilterFieldsNormalization(t *testing.T) {
+	normalizer, err := ParseKeyNormalizer([]string{"lowercase", "trim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qf := NewQuotientFilter(10, WithKeyNormalizer(normalizer))
+
+	// Trimming the framed key as a whole would leave the spaces of the
+	// first field in place.
+	qf.InsertFields([][]byte{[]byte(" Tenant "), []byte("ID-1")})
+	if exists, _ := qf.ExistsFields([][]byte{[]byte("tenant"), []byte("id-1")}); !exists {
+		t.Error("Expected each field to be normalized")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import "sync"
+
+// fifoEntry is a stored fingerprint with the sequence number of its insert.
+type fifoEntry struct {
+	fp  Fingerprint
+	seq uint64
+}
+
+// fifoTracker remembers the order in which fingerprints were added, so that
+// a full filter can evict the oldest one. Removed fingerprints are only
+// dropped from latest; their queue entries are skipped when they reach the
+// head, or compacted away once stale entries pile up.
+type fifoTracker struct {
+	mu     sync.Mutex
+	seq    uint64
+	queue  []fifoEntry // Oldest first, from head on
+	head   int
+	latest map[Fingerprint]uint64 // Sequence number of every stored fingerprint
+}
+
+func newFIFOTracker() *fifoTracker {
+	return &fifoTracker{latest: make(map[Fingerprint]uint64)}
+}
+
+// WithFIFOEviction turns the filter into a bounded set of the most recently
+// inserted items: once every slot is taken, an insert evicts the entry that
+// was added first instead of failing with ErrFilterFull. Re-inserting a
+// stored item does not refresh it. The insertion order takes roughly 70
+// bytes per stored entry on top of the 8 bytes per slot, and evicting takes
+// every stripe lock. Entries that are already in the slot array when the
+// filter is created are never evicted, so NewMmapQuotientFilter refuses FIFO
+// filters. ReadFrom takes the insertion order from the export.
+func WithFIFOEviction() FilterOption {
+	return func(qf *QuotientFilter) {
+		qf.fifo = newFIFOTracker()
+	}
+}
+
+// EvictsOldest reports whether the filter was created with WithFIFOEviction.
+func (qf *QuotientFilter) EvictsOldest() bool {
+	return qf.fifo != nil
+}
+
+// added records fp as the newest fingerprint.
+func (t *fifoTracker) added(fp Fingerprint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq++
+	t.queue = append(t.queue, fifoEntry{fp, t.seq})
+	t.latest[fp] = t.seq
+	if queued := len(t.queue) - t.head; queued > 2*len(t.latest)+64 {
+		t.compact()
+	}
+}
+
+// removed forgets fp.
+func (t *fifoTracker) removed(fp Fingerprint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.latest, fp)
+}
+
+// popOldest forgets and returns the oldest fingerprint still stored.
+func (t *fifoTracker) popOldest() (Fingerprint, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.head < len(t.queue) {
+		entry := t.queue[t.head]
+		t.queue[t.head] = fifoEntry{}
+		t.head++
+		if t.latest[entry.fp] == entry.seq {
+			delete(t.latest, entry.fp)
+			return entry.fp, true
+		}
+	}
+	return Fingerprint{}, false
+}
+
+// compact drops the stale entries and the popped prefix of the queue. The
+// caller must hold t.mu.
+func (t *fifoTracker) compact() {
+	live := make([]fifoEntry, 0, len(t.latest))
+	for _, entry := range t.queue[t.head:] {
+		if t.latest[entry.fp] == entry.seq {
+			live = append(live, entry)
+		}
+	}
+	t.queue, t.head = live, 0
+}
+
+// regroup rewrites every fingerprint with rehash, keeping their order, after
+// the filter geometry changed.
+func (t *fifoTracker) regroup(rehash func(Fingerprint) Fingerprint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.compact()
+	latest := make(map[Fingerprint]uint64, len(t.latest))
+	for i, entry := range t.queue {
+		t.queue[i].fp = rehash(entry.fp)
+		latest[t.queue[i].fp] = entry.seq
+	}
+	t.latest = latest
+}
+
+// order returns the stored fingerprints, oldest first.
+func (t *fifoTracker) order() []Fingerprint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	order := make([]Fingerprint, 0, len(t.latest))
+	for _, entry := range t.queue[t.head:] {
+		if t.latest[entry.fp] == entry.seq {
+			order = append(order, entry.fp)
+		}
+	}
+	return order
+}
+
+// replace swaps the insertion order for that of other, which must not be
+// used afterwards.
+func (t *fifoTracker) replace(other *fifoTracker) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq, t.queue, t.head, t.latest = other.seq, other.queue, other.head, other.latest
+}
+
+func (t *fifoTracker) clone() *fifoTracker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	clone := &fifoTracker{
+		seq:    t.seq,
+		queue:  append([]fifoEntry(nil), t.queue[t.head:]...),
+		latest: make(map[Fingerprint]uint64, len(t.latest)),
+	}
+	for fp, seq := range t.latest {
+		clone.latest[fp] = seq
+	}
+	return clone
+}
+
+// insertEvicting inserts data into a full FIFO filter, evicting the oldest
+// entries until there is room for it. It takes every stripe lock, since the
+// evicted entries may belong to any stripe.
+func (qf *QuotientFilter) insertEvicting(data []byte, hashValue uint64) (bool, error) {
+	qf.lockAll()
+	defer qf.unlockAll()
+
+	quotient, remainder := qf.split(hashValue)
+	if !qf.existsUnsafe(quotient, remainder) {
+		for qf.full() {
+			oldest, ok := qf.fifo.popOldest()
+			if !ok {
+				return false, ErrFilterFull
+			}
+			qf.evictUnsafe(oldest)
+		}
+	}
+	return qf.insertKeyUnsafe(quotient, remainder, data), nil
+}
+
+// evictUnsafe drops fp along with any key retained under it. The caller
+// must hold every stripe lock.
+func (qf *QuotientFilter) evictUnsafe(fp Fingerprint) {
+	if qf.keys != nil {
+		qf.keys.drop(fp)
+	}
+	if qf.removeUnsafe(fp.Quotient, fp.Remainder) {
+		qf.audit.RecordFingerprint("evict", fp)
+	}
+}
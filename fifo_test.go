@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQuotientFilterFIFOEviction(t *testing.T) {
+	qf := NewQuotientFilter(6, WithFIFOEviction())
+	capacity := qf.Capacity()
+
+	const total = 200
+	for i := 0; i < total; i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+
+	if qf.Count() != capacity {
+		t.Errorf("Expected the filter to stay full with %d items, but found %d", capacity, qf.Count())
+	}
+	if err := qf.Verify(); err != nil {
+		t.Fatalf("Filter is corrupted after evicting: %v", err)
+	}
+
+	for i := total - capacity; i < total; i++ {
+		if exists, _ := qf.Exists([]byte(fmt.Sprintf("item%d", i))); !exists {
+			t.Errorf("item%d is among the %d most recent inserts and should exist", i, capacity)
+		}
+	}
+	for i := 0; i < total-capacity; i++ {
+		if exists, _ := qf.Exists([]byte(fmt.Sprintf("item%d", i))); exists {
+			t.Errorf("item%d is among the oldest inserts and should have been evicted", i)
+		}
+	}
+}
+
+func TestQuotientFilterFIFOEvictionSkipsRemoved(t *testing.T) {
+	qf := NewQuotientFilter(4, WithFIFOEviction())
+	for i := 0; i < qf.Capacity(); i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	// item0 is the oldest; once removed and re-inserted it is the newest, so
+	// item1 goes first.
+	qf.Remove([]byte("item0"))
+	qf.Insert([]byte("item0"))
+	qf.Insert([]byte("overflow"))
+
+	if exists, _ := qf.Exists([]byte("item0")); !exists {
+		t.Error("Expected the re-inserted item0 to survive")
+	}
+	if exists, _ := qf.Exists([]byte("item1")); exists {
+		t.Error("Expected item1 to be evicted")
+	}
+
+	// Re-inserting a stored item must not evict anything.
+	count := qf.Count()
+	qf.Insert([]byte("overflow"))
+	if exists, _ := qf.Exists([]byte("item2")); !exists || qf.Count() != count {
+		t.Error("Expected a duplicate insert to leave the filter unchanged")
+	}
+}
+
+func TestQuotientFilterFIFOEvictionKeys(t *testing.T) {
+	qf := NewKeyRetainingQuotientFilter(4, WithFIFOEviction())
+	for i := 0; i <= qf.Capacity(); i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	for _, key := range qf.Keys() {
+		if string(key) == "item0" {
+			t.Error("Expected the key of the evicted item0 to be dropped")
+		}
+	}
+	if len(qf.Keys()) != qf.Capacity() {
+		t.Errorf("Expected %d keys, got %d", qf.Capacity(), len(qf.Keys()))
+	}
+}
+
+func TestQuotientFilterFIFOEvictionAfterResize(t *testing.T) {
+	qf := NewQuotientFilter(4, WithFIFOEviction())
+	for i := 0; i < qf.Capacity(); i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+	if err := qf.Resize(5); err != nil {
+		t.Fatalf("Failed to resize filter: %v", err)
+	}
+	for i := 16; i <= qf.Capacity(); i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	if exists, _ := qf.Exists([]byte("item0")); exists {
+		t.Error("Expected the oldest item to be evicted after resizing")
+	}
+	for i := 1; i <= qf.Capacity(); i++ {
+		if exists, _ := qf.Exists([]byte(fmt.Sprintf("item%d", i))); !exists {
+			t.Errorf("Expected item%d to exist", i)
+		}
+	}
+}
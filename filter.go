@@ -1,200 +1,749 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"hash/fnv"
+	"math"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	occupied = 1 << 0
-	runStart = 1 << 1
-	runEnd   = 1 << 2
-	shifted  = 1 << 3
-	stripes  = 16 // Number of stripes for striped locking
+	occupied = 1 << 0 // Some entry has this slot's index as its quotient
+	runStart = 1 << 1 // The entry in this slot is the first of its run
+	runEnd   = 1 << 2 // The entry in this slot is the last of its run
+	shifted  = 1 << 3 // The entry in this slot is not in its canonical slot
+
+	lockRetryInterval = 50 * time.Microsecond // Polling interval for context-aware lock acquisition
 )
 
 type QuotientFilter struct {
 	data     []uint64
 	mask     uint64
 	quotient uint
-	locks    [stripes]sync.RWMutex
+	locks    []sync.RWMutex // Stripe locks, a power of two of them
 	count    atomic.Int64
+	release  func() error // Frees external backing storage, if any
+	keys     *keyStore    // Original keys, only in key-retaining mode
+	fifo     *fifoTracker // Insertion order, only set by WithFIFOEviction
+
+	algorithm HashAlgorithm
+	seed      uint32
+	hashKey   func(data []byte) uint64
+
+	stripeStats []stripeCounters // Only set by WithStripeStats
+	negatives   *negativeCache   // Only set by WithNegativeCache
+
+	normalizer       KeyNormalizer // Only set by WithKeyNormalizer
+	maxWalk          int           // Slots a lookup may walk, 0 for no limit
+	audit            *AuditLog     // Only set by WithAuditLog
+	truncatedLookups atomic.Uint64
+}
+
+// ErrFilterFull is returned by Insert when every slot already holds an item.
+var ErrFilterFull = errors.New("filter is full")
+
+// FilterOption customizes a filter at construction.
+type FilterOption func(qf *QuotientFilter)
+
+// WithHashAlgorithm selects the function used to fingerprint keys. Filters
+// use DefaultHashAlgorithm unless told otherwise.
+func WithHashAlgorithm(algorithm HashAlgorithm) FilterOption {
+	return func(qf *QuotientFilter) {
+		qf.algorithm = algorithm
+	}
 }
 
-func NewQuotientFilter(logSize uint) *QuotientFilter {
+// WithHashSeed sets the Murmur3 seed, so fingerprints computed by other tools
+// with the same seed match the filter's own. It has no effect on FNV-1a.
+func WithHashSeed(seed uint32) FilterOption {
+	return func(qf *QuotientFilter) {
+		qf.seed = seed
+	}
+}
+
+// WithMaxWalk caps how many slots Exists and Trace walk, back to the start
+// of the cluster, across the runs before the key's and along its run, before
+// giving up, so a corrupted, endless cluster cannot hang a lookup. Such a
+// lookup answers false, but not with certainty, see ExistsWithCertainty.
+// Healthy clusters are a handful of slots long, so the cap should be far
+// above that; zero means no limit. Inserts and removals are not capped.
+func WithMaxWalk(slots int) FilterOption {
+	return func(qf *QuotientFilter) {
+		qf.maxWalk = slots
+	}
+}
+
+// NewQuotientFilter creates an in-memory filter of 2^logSize slots. It
+// panics when logSize leaves too few hash bits to the remainder, see
+// checkLogSize; validate sizes coming from users beforehand.
+func NewQuotientFilter(logSize uint, opts ...FilterOption) *QuotientFilter {
+	if err := checkLogSize(logSize); err != nil {
+		panic(err)
+	}
+
 	size := uint64(1) << logSize
-	return &QuotientFilter{
-		data:     make([]uint64, size),
-		mask:     size - 1,
-		quotient: logSize,
+	return newQuotientFilter(make([]uint64, size), logSize, opts)
+}
+
+func newQuotientFilter(data []uint64, logSize uint, opts []FilterOption) *QuotientFilter {
+	qf := &QuotientFilter{
+		data:      data,
+		mask:      uint64(1)<<logSize - 1,
+		quotient:  logSize,
+		locks:     make([]sync.RWMutex, defaultStripes),
+		algorithm: DefaultHashAlgorithm,
+	}
+
+	for _, opt := range opts {
+		opt(qf)
 	}
+	if qf.stripeStats != nil {
+		qf.stripeStats = make([]stripeCounters, len(qf.locks))
+	}
+	qf.hashKey = qf.algorithm.hasher(qf.seed)
+	return qf
+}
+
+// HashAlgorithm returns the function used to fingerprint keys.
+func (qf *QuotientFilter) HashAlgorithm() HashAlgorithm {
+	return qf.algorithm
+}
+
+// HashSeed returns the Murmur3 seed used to fingerprint keys.
+func (qf *QuotientFilter) HashSeed() uint32 {
+	return qf.seed
 }
 
 func (qf *QuotientFilter) Insert(data []byte) error {
-	quotient, remainder := qf.hash(data)
+	return qf.InsertContext(context.Background(), data)
+}
 
-	if qf.count.Load() >= int64(len(qf.data)) {
-		return fmt.Errorf("filter is full")
+// InsertContext is like Insert, but gives up with ctx.Err() if ctx is done
+// before the item has been written.
+func (qf *QuotientFilter) InsertContext(ctx context.Context, data []byte) error {
+	_, err := qf.insert(ctx, qf.normalize(data))
+	return err
+}
+
+// InsertNew is like Insert, but also reports whether data added a new
+// fingerprint, as opposed to duplicating one already stored. The check and
+// the insert happen under the same stripe locks, so among concurrent calls
+// with the same data exactly one reports true.
+func (qf *QuotientFilter) InsertNew(data []byte) (bool, error) {
+	return qf.insert(context.Background(), qf.normalize(data))
+}
+
+// insert stores data, which is already normalized.
+func (qf *QuotientFilter) insert(ctx context.Context, data []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
 
-	qf.lockStripe(quotient)
-	defer qf.unlockStripe(quotient)
+	hashValue := qf.hashKey(data)
 
-	exists := qf.existsUnsafe(quotient, remainder)
-	if exists {
-		return nil
+	added, err := qf.insertStripe(ctx, data, hashValue)
+	if errors.Is(err, ErrFilterFull) && qf.fifo != nil {
+		added, err = qf.insertEvicting(data, hashValue)
 	}
+	if added {
+		qf.audit.Record("insert", data)
+	}
+	return added, err
+}
 
-	slot := qf.findSlot(quotient)
-	qf.insertIntoSlot(slot, remainder, quotient)
-	qf.count.Add(1)
+// insertStripe inserts data under the stripe locks of its cluster alone.
+func (qf *QuotientFilter) insertStripe(ctx context.Context, data []byte, hashValue uint64) (bool, error) {
+	unlock, err := qf.lockCluster(ctx, hashValue, true)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	if qf.full() {
+		return false, ErrFilterFull
+	}
+
+	quotient, remainder := qf.split(hashValue)
+	return qf.insertKeyUnsafe(quotient, remainder, data), nil
+}
+
+// full reports whether every slot is taken.
+func (qf *QuotientFilter) full() bool {
+	return qf.count.Load() >= int64(len(qf.data))
+}
+
+// BatchResult reports how far InsertBatch got.
+type BatchResult struct {
+	Added     int `json:"added"`      // Keys that added a new fingerprint
+	StoppedAt int `json:"stopped_at"` // Index of the first key left out, or the number of keys
+}
+
+// InsertBatch inserts keys in order and stops at the first one that cannot
+// be stored, so that exactly keys[StoppedAt:] are left out. The error is the
+// one of keys[StoppedAt], typically ErrFilterFull. Keys are inserted one at a
+// time, so concurrent writers may interleave with the batch.
+func (qf *QuotientFilter) InsertBatch(keys [][]byte) (BatchResult, error) {
+	var result BatchResult
+	for ; result.StoppedAt < len(keys); result.StoppedAt++ {
+		added, err := qf.InsertNew(keys[result.StoppedAt])
+		if err != nil {
+			return result, err
+		}
+		if added {
+			result.Added++
+		}
+	}
+	return result, nil
+}
+
+// LoadFingerprints inserts precomputed fingerprints directly, bypassing the
+// hash function, so filters can be built by external pipelines that hash keys
+// with the same algorithm and seed. Every fingerprint is checked against the
+// filter's geometry before any is inserted. It is not available on
+// key-retaining filters, which need the original keys.
+func (qf *QuotientFilter) LoadFingerprints(fingerprints []Fingerprint) error {
+	if qf.keys != nil {
+		return errors.New("cannot load fingerprints into a key-retaining filter")
+	}
+
+	qf.lockAll()
+	defer qf.unlockAll()
+
+	for i, fp := range fingerprints {
+		if fp.Quotient > qf.mask {
+			return fmt.Errorf("fingerprint %d: quotient %d does not fit in %d bits", i, fp.Quotient, qf.quotient)
+		}
+		if qf.quotient > 0 && fp.Remainder>>(64-qf.quotient) != 0 {
+			return fmt.Errorf("fingerprint %d: remainder %d does not fit in %d bits", i, fp.Remainder, 64-qf.quotient)
+		}
+	}
+
+	for _, fp := range fingerprints {
+		if qf.full() {
+			return ErrFilterFull
+		}
+		if qf.insertUnsafe(fp.Quotient, fp.Remainder) {
+			qf.audit.RecordFingerprint("insert_fingerprint", fp)
+		}
+	}
 	return nil
 }
 
 func (qf *QuotientFilter) Exists(data []byte) (bool, time.Duration) {
+	exists, elapsed, _ := qf.ExistsContext(context.Background(), data)
+	return exists, elapsed
+}
+
+// ExistsContext is like Exists, but gives up with ctx.Err() if ctx is done
+// before the lookup could acquire its stripe locks.
+func (qf *QuotientFilter) ExistsContext(ctx context.Context, data []byte) (bool, time.Duration, error) {
 	startTime := time.Now()
-	quotient, remainder := qf.hash(data)
+	if err := ctx.Err(); err != nil {
+		return false, time.Since(startTime), err
+	}
+	exists, _, elapsed, err := qf.exists(ctx, qf.normalize(data), startTime)
+	return exists, elapsed, err
+}
 
-	qf.rLockStripe(quotient)
-	defer qf.rUnlockStripe(quotient)
+// exists looks up data, which is already normalized, and measures the time
+// elapsed since startTime. truncated reports a miss that only means the
+// lookup hit the WithMaxWalk cap.
+func (qf *QuotientFilter) exists(ctx context.Context, data []byte, startTime time.Time) (exists bool, truncated bool, elapsed time.Duration, err error) {
+	if qf.negatives != nil && qf.negatives.contains(data) {
+		return false, false, time.Since(startTime), nil
+	}
 
-	if !qf.isOccupied(quotient) {
-		return false, time.Since(startTime)
+	hashValue := qf.hashKey(data)
+
+	unlock, err := qf.lockCluster(ctx, hashValue, false)
+	if err != nil {
+		return false, false, time.Since(startTime), err
 	}
+	defer unlock()
 
-	runStart := qf.findRunStart(quotient)
-	runEnd := qf.findRunEnd(quotient)
+	quotient, remainder := qf.split(hashValue)
+	exists, truncated = qf.lookupUnsafe(quotient, remainder, qf.maxWalk)
+	if !exists && !truncated && qf.negatives != nil {
+		qf.negatives.add(data, quotient)
+	}
+	return exists, truncated, time.Since(startTime), nil
+}
 
-	for slot := runStart; ; slot = (slot + 1) & qf.mask {
-		if qf.getRemainder(slot) == remainder {
-			return true, time.Since(startTime)
-		}
-		if slot == runEnd {
-			break
+// FirstPresent looks keys up in order and returns the index of the first one
+// that may exist, without looking up the keys after it, or -1 and false when
+// none does. Each lookup only takes the stripe locks of its own cluster.
+func (qf *QuotientFilter) FirstPresent(keys [][]byte) (int, bool) {
+	for i, key := range keys {
+		if exists, _, _, _ := qf.exists(context.Background(), qf.normalize(key), time.Now()); exists {
+			return i, true
 		}
 	}
+	return -1, false
+}
 
-	return false, time.Since(startTime)
+// TruncatedLookups returns how many lookups hit the WithMaxWalk cap.
+func (qf *QuotientFilter) TruncatedLookups() uint64 {
+	return qf.truncatedLookups.Load()
 }
 
-func (qf *QuotientFilter) Remove(data []byte) bool {
-	quotient, remainder := qf.hash(data)
+// ExistsWithCertainty is like Exists, but spells out the filter's guarantee: a
+// miss is certain, while a hit may be a false positive. certain is therefore
+// true when present is false, unless the lookup gave up at the WithMaxWalk
+// cap, in which case the key may still be stored.
+func (qf *QuotientFilter) ExistsWithCertainty(data []byte) (present bool, certain bool) {
+	present, truncated, _, _ := qf.exists(context.Background(), qf.normalize(data), time.Now())
+	return present, !present && !truncated
+}
+
+// LookupTrace describes how a lookup walked the filter, to help explain
+// positives caused by long runs or fingerprint collisions.
+type LookupTrace struct {
+	Quotient    uint64  `json:"quotient"`
+	Remainder   uint64  `json:"remainder"`
+	Occupied    bool    `json:"occupied"`
+	RunStart    uint64  `json:"run_start"`
+	RunEnd      uint64  `json:"run_end"`
+	RunLength   int     `json:"run_length"`
+	Walked      int     `json:"walked"`
+	MatchedSlot *uint64 `json:"matched_slot,omitempty"`
+	Truncated   bool    `json:"truncated,omitempty"`
+}
+
+// Trace looks data up like Exists, and also reports the quotient, the
+// remainder compared, the bounds of the run and how many slots were walked.
+// The run fields are only set when the quotient is occupied. Finding the run
+// and measuring it count towards the WithMaxWalk cap; a trace that hits it is
+// marked truncated and reports a miss, with the run fields it got to.
+func (qf *QuotientFilter) Trace(data []byte) (bool, LookupTrace) {
+	hashValue := qf.hashKey(qf.normalize(data))
 
-	qf.lockStripe(quotient)
-	defer qf.unlockStripe(quotient)
+	unlock, _ := qf.lockCluster(context.Background(), hashValue, false)
+	defer unlock()
+
+	quotient, remainder := qf.split(hashValue)
+	trace := LookupTrace{Quotient: quotient, Remainder: remainder}
 
 	if !qf.isOccupied(quotient) {
-		return false
+		return false, trace
 	}
 
-	runStart := qf.findRunStart(quotient)
-	runEnd := qf.findRunEnd(quotient)
+	trace.Occupied = true
+	runStart, walked, ok := qf.walkToRun(quotient, qf.maxWalk)
+	if !ok {
+		trace.Truncated = true
+		return false, trace
+	}
+	trace.RunStart = runStart
+	trace.RunEnd = trace.RunStart
+	trace.RunLength = 1
+	for !qf.isRunEnd(trace.RunEnd) {
+		if qf.maxWalk > 0 && walked+trace.RunLength >= qf.maxWalk {
+			trace.Truncated = true
+			return false, trace
+		}
+		trace.RunEnd = (trace.RunEnd + 1) & qf.mask
+		trace.RunLength++
+	}
 
-	for slot := runStart; ; slot = (slot + 1) & qf.mask {
+	for slot := trace.RunStart; ; slot = (slot + 1) & qf.mask {
+		trace.Walked++
 		if qf.getRemainder(slot) == remainder {
-			qf.removeAt(slot, quotient, runStart, runEnd)
-			qf.count.Add(-1)
-			return true
+			trace.MatchedSlot = &slot
+			return true, trace
 		}
-		if slot == runEnd {
-			break
+		if slot == trace.RunEnd {
+			return false, trace
 		}
 	}
+}
+
+func (qf *QuotientFilter) Remove(data []byte) bool {
+	removed, _ := qf.RemoveContext(context.Background(), data)
+	return removed
+}
+
+// RemoveContext is like Remove, but gives up with ctx.Err() if ctx is done
+// before the item has been removed.
+func (qf *QuotientFilter) RemoveContext(ctx context.Context, data []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return qf.remove(ctx, qf.normalize(data))
+}
 
-	return false
+// remove deletes data, which is already normalized.
+func (qf *QuotientFilter) remove(ctx context.Context, data []byte) (bool, error) {
+	hashValue := qf.hashKey(data)
+
+	unlock, err := qf.lockCluster(ctx, hashValue, true)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	quotient, remainder := qf.split(hashValue)
+	removed := qf.removeKeyUnsafe(quotient, remainder, data)
+	if removed {
+		qf.audit.Record("remove", data)
+	}
+	return removed, nil
 }
 
 func (qf *QuotientFilter) Count() int {
 	return int(qf.count.Load())
 }
 
-func (qf *QuotientFilter) existsUnsafe(quotient, remainder uint64) bool {
-	if !qf.isOccupied(quotient) {
-		return false
+// Warmup writes to every memory page of the slot array so that the OS maps
+// them up front, instead of page-faulting during the first wave of inserts.
+// It costs a pass over the whole array and leaves the content untouched.
+func (qf *QuotientFilter) Warmup() {
+	qf.rLockAll()
+	defer qf.rUnlockAll()
+
+	slotsPerPage := os.Getpagesize() / 8
+	for slot := 0; slot < len(qf.data); slot += slotsPerPage {
+		atomic.AddUint64(&qf.data[slot], 0)
 	}
+}
 
-	runStart := qf.findRunStart(quotient)
-	runEnd := qf.findRunEnd(quotient)
+// Close releases the storage backing the filter, flushing it to disk for
+// file-backed filters. It waits for in-flight operations; the filter must not
+// be used afterwards.
+func (qf *QuotientFilter) Close() error {
+	if qf.release == nil {
+		return nil
+	}
 
-	for slot := runStart; ; slot = (slot + 1) & qf.mask {
-		if qf.getRemainder(slot) == remainder {
-			return true
+	qf.lockAll()
+	defer qf.unlockAll()
+
+	release := qf.release
+	qf.release = nil
+	return release()
+}
+
+// countEntries scans the slot array for stored entries, regardless of the
+// count kept alongside it.
+func (qf *QuotientFilter) countEntries() int64 {
+	var entries int64
+	for slot := range qf.data {
+		if !qf.isEmpty(uint64(slot)) {
+			entries++
 		}
-		if slot == runEnd {
-			break
+	}
+	return entries
+}
+
+// Capacity returns the number of slots in the filter.
+func (qf *QuotientFilter) Capacity() int {
+	qf.rLockAll()
+	defer qf.rUnlockAll()
+
+	return len(qf.data)
+}
+
+// LoadFactor returns the fraction of slots holding an item.
+func (qf *QuotientFilter) LoadFactor() float64 {
+	return float64(qf.Count()) / float64(qf.Capacity())
+}
+
+// Checksum hashes the set of stored fingerprints into a single value. The
+// per-entry hashes are folded with an addition, so two filters of the same
+// size holding the same items have the same checksum regardless of the
+// order the items were inserted in or how their clusters are laid out.
+func (qf *QuotientFilter) Checksum() uint64 {
+	qf.rLockAll()
+	defer qf.rUnlockAll()
+
+	var checksum uint64
+	qf.forEachEntry(func(quotient, remainder uint64) {
+		checksum += mix64(remainder<<qf.quotient | quotient)
+	})
+	return checksum
+}
+
+// EstimateCardinality approximates the number of distinct items stored from
+// the fraction of occupied quotients alone, using linear counting: with k of
+// m quotients occupied, about -m*ln(1-k/m) items were inserted. It does not
+// look at the stored count, so it stays meaningful on a filter whose count
+// cannot be trusted. It holds every stripe read lock for the scan, and the
+// result is clamped to the filter size.
+func (qf *QuotientFilter) EstimateCardinality() int {
+	qf.rLockAll()
+	defer qf.rUnlockAll()
+
+	size := len(qf.data)
+
+	occupiedQuotients := 0
+	for slot := range qf.data {
+		if qf.isOccupied(uint64(slot)) {
+			occupiedQuotients++
 		}
 	}
 
-	return false
+	if occupiedQuotients == size {
+		return size
+	}
+
+	estimate := -float64(size) * math.Log(1-float64(occupiedQuotients)/float64(size))
+	return int(math.Min(math.Round(estimate), float64(size)))
 }
 
-func (qf *QuotientFilter) hash(data []byte) (quotient uint64, remainder uint64) {
-	h := fnv.New64a()
-	h.Write(data)
-	hashValue := h.Sum64()
-	quotient = hashValue & qf.mask
-	remainder = hashValue >> qf.quotient
-	return
+// RunLengthStats summarizes how many entries each run holds. Long runs mean
+// lookups walk many slots, and usually show up before the load factor gets
+// alarming.
+type RunLengthStats struct {
+	Runs int     `json:"runs"`
+	Min  int     `json:"min"`
+	Max  int     `json:"max"`
+	Mean float64 `json:"mean"`
+	P99  int     `json:"p99"`
 }
 
-func (qf *QuotientFilter) removeAt(slot, quotient, runStart, runEnd uint64) {
-	isOnlyItemInRun := runStart == runEnd
-	isFirstItemInRun := slot == runStart
-	isLastItemInRun := slot == runEnd
+// RunLengthStats scans every run under all stripe read locks. All fields are
+// zero on an empty filter.
+func (qf *QuotientFilter) RunLengthStats() RunLengthStats {
+	qf.rLockAll()
+	defer qf.rUnlockAll()
 
-	if isOnlyItemInRun {
-		qf.clearOccupied(quotient)
-		qf.clearRunStart(slot)
-		qf.clearRunEnd(slot)
-		qf.clearRemainder(slot)
-		qf.clearShifted(slot)
-	} else {
-		qf.shiftLeft(slot, runEnd)
-
-		if isFirstItemInRun {
-			newRunStart := (slot + 1) & qf.mask
-			qf.clearRunStart(slot)
-			qf.setRunStart(newRunStart)
-			if newRunStart != quotient {
-				qf.setShifted(newRunStart)
+	var stats RunLengthStats
+	origin, found := qf.clusterBoundary()
+	if !found {
+		return stats
+	}
+
+	// runsOfLength[n] counts the runs holding n entries.
+	var runsOfLength []int
+	var entries, length int
+	for offset := range qf.data {
+		slot := (origin + uint64(offset)) & qf.mask
+		if qf.isEmpty(slot) {
+			continue
+		}
+
+		if qf.isRunStart(slot) {
+			length = 0
+		}
+		length++
+		if qf.isRunEnd(slot) {
+			for len(runsOfLength) <= length {
+				runsOfLength = append(runsOfLength, 0)
 			}
+			runsOfLength[length]++
+			stats.Runs++
+			entries += length
+		}
+	}
+	if stats.Runs == 0 {
+		return stats
+	}
+
+	stats.Max = len(runsOfLength) - 1
+	stats.Mean = float64(entries) / float64(stats.Runs)
+	rank := int(math.Ceil(0.99 * float64(stats.Runs)))
+	seen := 0
+	for length, runs := range runsOfLength {
+		if runs == 0 {
+			continue
 		}
-		if isLastItemInRun {
-			newRunEnd := (runEnd - 1) & qf.mask
-			qf.clearRunEnd(runEnd)
-			qf.setRunEnd(newRunEnd)
+		if stats.Min == 0 {
+			stats.Min = length
 		}
+		seen += runs
+		if seen >= rank && stats.P99 == 0 {
+			stats.P99 = length
+		}
+	}
+	return stats
+}
+
+// SlotDump is the decoded content of a single slot, as returned by Dump.
+type SlotDump struct {
+	Slot      uint64 `json:"slot"`
+	Occupied  bool   `json:"occupied"`
+	RunStart  bool   `json:"run_start"`
+	RunEnd    bool   `json:"run_end"`
+	Shifted   bool   `json:"shifted"`
+	Remainder uint64 `json:"remainder"`
+}
+
+// Dump decodes the metadata and remainder of every slot in [from, to).
+// It holds all stripe read locks, so the range is a consistent view even on
+// a live filter. Bounds past the end of the filter are clamped.
+func (qf *QuotientFilter) Dump(from, to uint64) []SlotDump {
+	size := uint64(len(qf.data))
+	if to > size {
+		to = size
+	}
+	if from >= to {
+		return []SlotDump{}
+	}
+
+	qf.rLockAll()
+	defer qf.rUnlockAll()
+
+	slots := make([]SlotDump, 0, to-from)
+	for slot := from; slot < to; slot++ {
+		slots = append(slots, SlotDump{
+			Slot:      slot,
+			Occupied:  qf.isOccupied(slot),
+			RunStart:  qf.isRunStart(slot),
+			RunEnd:    qf.isRunEnd(slot),
+			Shifted:   qf.isShifted(slot),
+			Remainder: qf.getRemainder(slot),
+		})
 	}
+	return slots
+}
 
-	qf.updateMetadataAfterRemoval(quotient)
+// existsUnsafe is the lookup internal callers build on, such as
+// insertEvicting. It is not capped by WithMaxWalk: an answer cut short there
+// would make them act on a key as if it were missing.
+func (qf *QuotientFilter) existsUnsafe(quotient, remainder uint64) bool {
+	exists, _ := qf.lookupUnsafe(quotient, remainder, 0)
+	return exists
 }
 
-func (qf *QuotientFilter) updateMetadataAfterRemoval(quotient uint64) {
+// lookupUnsafe walks the run of quotient looking for remainder. With maxWalk
+// set, it gives up once it has walked that many slots, counting the walk to
+// the run, and reports the lookup as truncated, with exists set to false.
+func (qf *QuotientFilter) lookupUnsafe(quotient, remainder uint64, maxWalk int) (exists bool, truncated bool) {
 	if !qf.isOccupied(quotient) {
-		return
+		return false, false
+	}
+
+	slot, walked, ok := qf.walkToRun(quotient, maxWalk)
+	for ; ; slot = (slot + 1) & qf.mask {
+		if !ok || maxWalk > 0 && walked >= maxWalk {
+			qf.truncatedLookups.Add(1)
+			return false, true
+		}
+		walked++
+
+		if qf.getRemainder(slot) == remainder {
+			return true, false
+		}
+		if qf.isRunEnd(slot) {
+			return false, false
+		}
+	}
+}
+
+// insertUnsafe stores remainder in the run of quotient, keeping runs sorted by
+// quotient within their cluster. It reports whether the remainder was newly
+// added. The caller must hold the stripe locks of the cluster, see
+// lockCluster.
+func (qf *QuotientFilter) insertUnsafe(quotient, remainder uint64) bool {
+	if qf.negatives != nil {
+		qf.negatives.invalidate(quotient)
+	}
+
+	if qf.isEmpty(quotient) {
+		qf.setEntry(quotient, remainder, runStart|runEnd)
+		qf.setOccupied(quotient)
+		qf.count.Add(1)
+		if qf.fifo != nil {
+			qf.fifo.added(Fingerprint{quotient, remainder})
+		}
+		return true
 	}
 
-	runStart := qf.findRunStart(quotient)
-	runEnd := qf.findRunEnd(quotient)
+	var slot, metadata uint64
+	if qf.isOccupied(quotient) {
+		// Append to the existing run, unless the remainder is already in it.
+		end := qf.findRunStart(quotient)
+		for {
+			if qf.getRemainder(end) == remainder {
+				return false
+			}
+			if qf.isRunEnd(end) {
+				break
+			}
+			end = (end + 1) & qf.mask
+		}
 
-	if runStart == runEnd {
-		qf.setRunStart(runStart)
-		qf.setRunEnd(runStart)
-		qf.clearShifted(runStart)
+		slot = (end + 1) & qf.mask
+		metadata = runEnd | shifted
+		qf.clearRunEnd(end)
 	} else {
-		qf.setRunStart(runStart)
-		qf.setRunEnd(runEnd)
-		for slot := (runStart + 1) & qf.mask; slot != (runEnd+1)&qf.mask; slot = (slot + 1) & qf.mask {
-			qf.setShifted(slot)
+		// Open a new run right after the runs of the preceding quotients.
+		qf.setOccupied(quotient)
+		slot = qf.findRunStart(quotient)
+		metadata = runStart | runEnd
+		if slot != quotient {
+			metadata |= shifted
+		}
+	}
+
+	qf.shiftRight(slot)
+	qf.setEntry(slot, remainder, metadata)
+	qf.count.Add(1)
+	if qf.fifo != nil {
+		qf.fifo.added(Fingerprint{quotient, remainder})
+	}
+	return true
+}
+
+// removeUnsafe deletes remainder from the run of quotient and shifts the rest
+// of the cluster back. It reports whether the remainder was found. The caller
+// must hold the stripe locks of the cluster, see lockCluster.
+func (qf *QuotientFilter) removeUnsafe(quotient, remainder uint64) bool {
+	if !qf.isOccupied(quotient) {
+		return false
+	}
+
+	slot := qf.findRunStart(quotient)
+	for qf.getRemainder(slot) != remainder {
+		if qf.isRunEnd(slot) {
+			return false
 		}
+		slot = (slot + 1) & qf.mask
 	}
 
-	if qf.getRemainder(runStart) == 0 && !qf.isShifted(runStart) {
+	isFirst, isLast := qf.isRunStart(slot), qf.isRunEnd(slot)
+	switch {
+	case isFirst && isLast:
 		qf.clearOccupied(quotient)
+		qf.shiftLeft(slot, quotient)
+	case isFirst:
+		// The next entry of the run moves into slot and takes over the start.
+		qf.shiftLeft(slot, quotient)
+		qf.setRunStart(slot)
+	case isLast:
+		qf.setRunEnd((slot - 1) & qf.mask)
+		qf.shiftLeft(slot, quotient)
+	default:
+		qf.shiftLeft(slot, quotient)
+	}
+
+	qf.count.Add(-1)
+	if qf.fifo != nil {
+		qf.fifo.removed(Fingerprint{quotient, remainder})
 	}
+	return true
+}
+
+func (qf *QuotientFilter) hash(data []byte) (quotient uint64, remainder uint64) {
+	return qf.split(qf.hashKey(data))
+}
+
+// split cuts a hash into the quotient and remainder of the current geometry.
+// Callers serving a key must hold its stripe lock, so that a concurrent
+// Resize cannot change the geometry in between.
+func (qf *QuotientFilter) split(hashValue uint64) (quotient uint64, remainder uint64) {
+	return hashValue & qf.mask, hashValue >> qf.quotient
 }
 
 func (qf *QuotientFilter) isOccupied(index uint64) bool {
@@ -297,97 +846,79 @@ func (qf *QuotientFilter) setRemainder(index uint64, remainder uint64) {
 	}
 }
 
-func (qf *QuotientFilter) findSlot(quotient uint64) uint64 {
-	slot := quotient
-	for qf.isOccupied(slot) {
-		if !qf.isShifted(slot) {
-			break
-		}
-		slot = (slot + 1) & qf.mask
-	}
-	return slot
+func (qf *QuotientFilter) isEmpty(index uint64) bool {
+	return atomic.LoadUint64(&qf.data[index&qf.mask])&(runStart|runEnd|shifted) == 0
 }
 
-func (qf *QuotientFilter) insertRemainder(slot uint64, remainder uint64, quotient uint64) error {
-	if qf.isFull() {
-		return fmt.Errorf("filter is full")
-	}
-
-	currentSlot := slot
+// setEntry stores remainder and the run metadata of an entry in a slot. The
+// occupied bit describes the slot's quotient rather than the entry stored in
+// it, so it is left untouched.
+func (qf *QuotientFilter) setEntry(index uint64, remainder uint64, metadata uint64) {
 	for {
-		if !qf.isOccupied(currentSlot) {
-			break
-		}
-		if qf.getRemainder(currentSlot) == remainder {
-			return nil // Item already exists, don't insert or increment count
-		}
-		if qf.isRunEnd(currentSlot) {
-			break
+		old := atomic.LoadUint64(&qf.data[index&qf.mask])
+		new := (old & occupied) | (remainder << 4) | metadata
+		if atomic.CompareAndSwapUint64(&qf.data[index&qf.mask], old, new) {
+			return
 		}
-		currentSlot = (currentSlot + 1) & qf.mask
 	}
+}
 
-	if currentSlot != slot {
-		err := qf.shiftRight(currentSlot)
-		if err != nil {
-			return err
-		}
-	}
+func (qf *QuotientFilter) clearEntry(index uint64) {
+	qf.setEntry(index, 0, 0)
+}
 
-	qf.setOccupied(currentSlot)
-	qf.setRemainder(currentSlot, remainder)
+// moveEntry copies the entry stored at from into to, marking it shifted.
+func (qf *QuotientFilter) moveEntry(from, to uint64) {
+	entry := atomic.LoadUint64(&qf.data[from&qf.mask]) &^ occupied
+	qf.setEntry(to, entry>>4, (entry&0xF)|shifted)
+}
 
-	if currentSlot != quotient {
-		qf.setShifted(currentSlot)
-	}
-	if currentSlot == quotient || !qf.isRunStart(currentSlot) {
-		qf.setRunStart(currentSlot)
+// shiftRight makes room at slot by moving every entry up to the next empty
+// slot one position to the right.
+func (qf *QuotientFilter) shiftRight(slot uint64) {
+	empty := slot
+	for !qf.isEmpty(empty) {
+		empty = (empty + 1) & qf.mask
 	}
 
-	nextSlot := (currentSlot + 1) & qf.mask
-	if !qf.isOccupied(nextSlot) || qf.isRunStart(nextSlot) {
-		qf.setRunEnd(currentSlot)
-	} else {
-		qf.clearRunEnd((currentSlot - 1) & qf.mask)
+	for current := empty; current != slot; current = (current - 1) & qf.mask {
+		qf.moveEntry((current-1)&qf.mask, current)
 	}
-
-	qf.count.Add(1)
-	return nil
 }
 
-func (qf *QuotientFilter) shiftRight(slot uint64) error {
-	currentSlot := slot
-	for {
-		nextSlot := (currentSlot + 1) & qf.mask
-		if !qf.isOccupied(nextSlot) {
-			break
+// shiftLeft closes the gap left by the entry removed from slot, which
+// belonged to quotient. Entries move back until the cluster ends or an entry
+// already sits in its canonical slot; a run start that lands on its own
+// quotient is no longer shifted.
+func (qf *QuotientFilter) shiftLeft(slot uint64, quotient uint64) {
+	current := slot
+	next := (slot + 1) & qf.mask
+	runQuotient := quotient
+
+	for !qf.isEmpty(next) && qf.isShifted(next) {
+		if qf.isRunStart(next) {
+			runQuotient = qf.nextOccupied(runQuotient)
 		}
-		currentSlot = nextSlot
-	}
 
-	for currentSlot != slot {
-		prevSlot := (currentSlot - 1) & qf.mask
-		atomic.StoreUint64(&qf.data[currentSlot], atomic.LoadUint64(&qf.data[prevSlot]))
-		qf.setShifted(currentSlot)
-		currentSlot = prevSlot
-	}
+		qf.moveEntry(next, current)
+		if current == runQuotient {
+			qf.clearShifted(current)
+		}
 
-	qf.clearSlot(slot) // Clear the original slot
-	return nil
-}
+		current = next
+		next = (next + 1) & qf.mask
+	}
 
-func (qf *QuotientFilter) clearSlot(index uint64) {
-	atomic.StoreUint64(&qf.data[index&qf.mask], 0)
+	qf.clearEntry(current)
 }
 
-func (qf *QuotientFilter) clearRemainder(index uint64) {
-	for {
-		old := atomic.LoadUint64(&qf.data[index&qf.mask])
-		new := old & 0xF // Clear all but the lowest 4 bits (metadata)
-		if atomic.CompareAndSwapUint64(&qf.data[index&qf.mask], old, new) {
-			return
-		}
+// nextOccupied returns the first occupied quotient after the given one.
+func (qf *QuotientFilter) nextOccupied(quotient uint64) uint64 {
+	next := (quotient + 1) & qf.mask
+	for !qf.isOccupied(next) {
+		next = (next + 1) & qf.mask
 	}
+	return next
 }
 
 func (qf *QuotientFilter) clearShifted(index uint64) {
@@ -400,97 +931,184 @@ func (qf *QuotientFilter) clearShifted(index uint64) {
 	}
 }
 
-func (qf *QuotientFilter) shiftLeft(start, end uint64) {
-	current := start
-	next := (start + 1) & qf.mask
+// clusterBoundary returns a slot from which a linear scan starts outside of
+// any cluster: an empty slot if there is one, otherwise (on a full filter) an
+// entry sitting in its own slot.
+func (qf *QuotientFilter) clusterBoundary() (uint64, bool) {
+	for slot := range qf.data {
+		if qf.isEmpty(uint64(slot)) {
+			return uint64(slot), true
+		}
+	}
+	for slot := range qf.data {
+		if !qf.isShifted(uint64(slot)) {
+			return uint64(slot), true
+		}
+	}
+	return 0, false
+}
 
-	for current != end {
-		atomic.StoreUint64(&qf.data[current], atomic.LoadUint64(&qf.data[next]))
-		current = next
-		next = (next + 1) & qf.mask
+// forEachEntry calls fn with the quotient and remainder of every stored
+// entry, scanning the slots once from a cluster boundary. The caller must
+// hold every stripe lock.
+func (qf *QuotientFilter) forEachEntry(fn func(quotient, remainder uint64)) {
+	origin, found := qf.clusterBoundary()
+	if !found {
+		return
 	}
 
-	qf.clearSlot(end)
-}
+	var runQuotient uint64
+	for offset := range qf.data {
+		slot := (origin + uint64(offset)) & qf.mask
+		if qf.isEmpty(slot) {
+			continue
+		}
 
-func (qf *QuotientFilter) isFull() bool {
-	return qf.count.Load() >= int64(len(qf.data))
+		if qf.isRunStart(slot) {
+			if qf.isShifted(slot) {
+				runQuotient = qf.nextOccupied(runQuotient)
+			} else {
+				runQuotient = slot
+			}
+		}
+		fn(runQuotient, qf.getRemainder(slot))
+	}
 }
 
+// findRunStart returns the slot holding the first entry of quotient's run,
+// or the slot where that run would begin. It walks back to the start of the
+// cluster, then skips one run per occupied quotient until it reaches
+// quotient. The occupied bit of quotient must be set. The walk is not capped;
+// lookups use walkToRun to honour WithMaxWalk.
 func (qf *QuotientFilter) findRunStart(quotient uint64) uint64 {
-	slot := quotient
-	for qf.isShifted(slot) && !qf.isRunStart(slot) {
-		slot = (slot - 1) & qf.mask
-	}
+	slot, _, _ := qf.walkToRun(quotient, 0)
 	return slot
 }
 
-func (qf *QuotientFilter) findRunEnd(quotient uint64) uint64 {
-	slot := qf.findRunStart(quotient)
-	for !qf.isRunEnd(slot) {
-		nextSlot := (slot + 1) & qf.mask
-		if !qf.isShifted(nextSlot) {
-			break
+// walkToRun is findRunStart, also returning how many slots it stepped over.
+// With maxWalk set, it gives up with ok false after that many.
+func (qf *QuotientFilter) walkToRun(quotient uint64, maxWalk int) (slot uint64, walked int, ok bool) {
+	clusterStart := quotient
+	for qf.isShifted(clusterStart) {
+		if maxWalk > 0 && walked >= maxWalk {
+			return 0, walked, false
 		}
-		slot = nextSlot
+		clusterStart = (clusterStart - 1) & qf.mask
+		walked++
 	}
-	return slot
+
+	slot = clusterStart
+	for current := clusterStart; current != quotient; current = qf.nextOccupied(current) {
+		for !qf.isRunEnd(slot) {
+			if maxWalk > 0 && walked >= maxWalk {
+				return 0, walked, false
+			}
+			slot = (slot + 1) & qf.mask
+			walked++
+		}
+		slot = (slot + 1) & qf.mask
+		walked++
+	}
+	return slot, walked, true
 }
 
-func (qf *QuotientFilter) insertIntoSlot(slot uint64, remainder uint64, quotient uint64) {
-	var prevRemainder, currRemainder uint64
-	var prevMetadata, currMetadata uint64
-	originalSlot := slot
+// stripe picks the stripe owning index from its low bits. Single-key
+// operations pass the full hash, whose low bits are those of the quotient, so
+// a key keeps its stripe across a Resize.
+func (qf *QuotientFilter) stripe(index uint64) int {
+	return int(index & uint64(len(qf.locks)-1))
+}
 
-	currRemainder = remainder
-	currMetadata = occupied
-	if slot != quotient {
-		currMetadata |= shifted
-	}
-	if slot == quotient || !qf.isRunStart(slot) {
-		currMetadata |= runStart
-	}
+func (qf *QuotientFilter) lockStripe(index uint64) {
+	start := qf.stripeClock()
+	qf.locks[qf.stripe(index)].Lock()
+	qf.recordStripeLock(index, start)
+}
 
-	for {
-		if !qf.isOccupied(slot) {
-			qf.setRemainder(slot, currRemainder)
-			atomic.StoreUint64(&qf.data[slot], (qf.data[slot]&^uint64(0xF))|currMetadata|runEnd)
-			return
-		}
+func (qf *QuotientFilter) unlockStripe(index uint64) {
+	qf.locks[qf.stripe(index)].Unlock()
+}
 
-		prevRemainder = qf.getRemainder(slot)
-		prevMetadata = atomic.LoadUint64(&qf.data[slot]) & 0xF
+func (qf *QuotientFilter) rLockStripe(index uint64) {
+	start := qf.stripeClock()
+	qf.locks[qf.stripe(index)].RLock()
+	qf.recordStripeLock(index, start)
+}
 
-		qf.setRemainder(slot, currRemainder)
-		atomic.StoreUint64(&qf.data[slot], (qf.data[slot]&^uint64(0xF))|currMetadata)
+func (qf *QuotientFilter) rUnlockStripe(index uint64) {
+	qf.locks[qf.stripe(index)].RUnlock()
+}
 
-		currRemainder = prevRemainder
-		currMetadata = prevMetadata | shifted
+func (qf *QuotientFilter) lockAll() {
+	for i := range qf.locks {
+		qf.locks[i].Lock()
+	}
+}
 
-		if qf.isRunEnd(slot) {
-			qf.clearRunEnd(slot)
-			currMetadata |= runEnd
-		}
+func (qf *QuotientFilter) unlockAll() {
+	for i := range qf.locks {
+		qf.locks[i].Unlock()
+	}
+}
 
-		slot = (slot + 1) & qf.mask
-		if slot == originalSlot {
-			panic("Filter is full")
-		}
+func (qf *QuotientFilter) rLockAll() {
+	for i := range qf.locks {
+		qf.locks[i].RLock()
 	}
 }
 
-func (qf *QuotientFilter) lockStripe(index uint64) {
-	qf.locks[index%stripes].Lock()
+func (qf *QuotientFilter) rUnlockAll() {
+	for i := range qf.locks {
+		qf.locks[i].RUnlock()
+	}
 }
 
-func (qf *QuotientFilter) unlockStripe(index uint64) {
-	qf.locks[index%stripes].Unlock()
+// lockStripeContext acquires the write lock of the stripe owning index,
+// polling so that a caller stuck behind a long-held lock can bail out once
+// ctx is done. Contexts that can never be cancelled take the plain lock.
+func (qf *QuotientFilter) lockStripeContext(ctx context.Context, index uint64) error {
+	start := qf.stripeClock()
+	lock := &qf.locks[qf.stripe(index)]
+	if ctx.Done() == nil {
+		lock.Lock()
+	} else {
+		for !lock.TryLock() {
+			if err := waitRetry(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	qf.recordStripeLock(index, start)
+	return nil
 }
 
-func (qf *QuotientFilter) rLockStripe(index uint64) {
-	qf.locks[index%stripes].RLock()
+// rLockStripeContext is the read-lock counterpart of lockStripeContext.
+func (qf *QuotientFilter) rLockStripeContext(ctx context.Context, index uint64) error {
+	start := qf.stripeClock()
+	lock := &qf.locks[qf.stripe(index)]
+	if ctx.Done() == nil {
+		lock.RLock()
+	} else {
+		for !lock.TryRLock() {
+			if err := waitRetry(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	qf.recordStripeLock(index, start)
+	return nil
 }
 
-func (qf *QuotientFilter) rUnlockStripe(index uint64) {
-	qf.locks[index%stripes].RUnlock()
+func waitRetry(ctx context.Context) error {
+	timer := time.NewTimer(lockRetryInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
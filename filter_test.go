@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"math"
 	"math/rand"
 	"testing"
 	"time"
+
+	"github.com/spaolacci/murmur3"
 )
 
 const (
@@ -58,6 +64,28 @@ func BenchmarkQuotientFilterExists(b *testing.B) {
 	})
 }
 
+func BenchmarkQuotientFilterFirstInserts(b *testing.B) {
+	const logSize = 24 // 2^24 slots, 128MB
+	keys := generateRandomUUIDs(1000)
+
+	for _, warmup := range []bool{false, true} {
+		b.Run(fmt.Sprintf("warmup=%t", warmup), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				qf := NewQuotientFilter(logSize)
+				if warmup {
+					qf.Warmup()
+				}
+				b.StartTimer()
+
+				for _, key := range keys {
+					qf.Insert(key)
+				}
+			}
+		})
+	}
+}
+
 func TestQuotientFilterBasic(t *testing.T) {
 	qf := NewQuotientFilter(10)
 
@@ -341,3 +369,412 @@ func TestQuotientFilterRemove(t *testing.T) {
 		}
 	})
 }
+
+func TestQuotientFilterClusterChurn(t *testing.T) {
+	// A small, crowded filter, so that runs share long clusters and removals
+	// shift entries of other quotients back.
+	qf := NewQuotientFilter(8)
+	rng := rand.New(rand.NewSource(1))
+
+	keys := make([]uint64, 200)
+	for i := range keys {
+		keys[i] = rng.Uint64()
+		if err := qf.Insert(uint64ToBytes(keys[i])); err != nil {
+			t.Fatalf("Failed to insert key %d: %v", keys[i], err)
+		}
+	}
+	for _, key := range keys {
+		if exists, _ := qf.Exists(uint64ToBytes(key)); !exists {
+			t.Errorf("Key %d should exist after inserting, but doesn't", key)
+		}
+	}
+
+	live := 0
+	for i, key := range keys {
+		if i%2 == 0 {
+			if !qf.Remove(uint64ToBytes(key)) {
+				t.Errorf("Key %d should have been removed", key)
+			}
+		} else {
+			live++
+		}
+	}
+	for i, key := range keys {
+		if exists, _ := qf.Exists(uint64ToBytes(key)); i%2 == 1 && !exists {
+			t.Errorf("Key %d should exist after removing its neighbours, but doesn't", key)
+		}
+	}
+	if qf.Count() != live {
+		t.Errorf("Expected %d items after removals, but found %d", live, qf.Count())
+	}
+}
+
+func TestQuotientFilterContext(t *testing.T) {
+	t.Run("Cancelled context aborts before inserting", func(t *testing.T) {
+		qf := NewQuotientFilter(8)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := qf.InsertContext(ctx, []byte("item"))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+		if qf.Count() != 0 {
+			t.Errorf("Expected 0 items in the filter, but found %d", qf.Count())
+		}
+		if exists, _ := qf.Exists([]byte("item")); exists {
+			t.Error("Item should not have been inserted")
+		}
+
+		if _, _, err := qf.ExistsContext(ctx, []byte("item")); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled from ExistsContext, got %v", err)
+		}
+	})
+
+	t.Run("Deadline expires while waiting for a stripe lock", func(t *testing.T) {
+		qf := NewQuotientFilter(8)
+		data := []byte("item")
+		if err := qf.Insert(data); err != nil {
+			t.Fatalf("Failed to insert item: %v", err)
+		}
+
+		quotient, _ := qf.hash(data)
+		qf.lockStripe(quotient)
+		defer qf.unlockStripe(quotient)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		removed, err := qf.RemoveContext(ctx, data)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if removed {
+			t.Error("Item should not have been removed")
+		}
+		if qf.Count() != 1 {
+			t.Errorf("Expected 1 item in the filter, but found %d", qf.Count())
+		}
+	})
+}
+
+func TestQuotientFilterEstimateCardinality(t *testing.T) {
+	const logSize = 12
+	qf := NewQuotientFilter(logSize)
+
+	if estimate := qf.EstimateCardinality(); estimate != 0 {
+		t.Errorf("Expected an estimate of 0 for an empty filter, got %d", estimate)
+	}
+
+	for i := 0; i < (1<<logSize)/2; i++ {
+		if err := qf.Insert(uint64ToBytes(rand.Uint64())); err != nil {
+			t.Fatalf("Failed to insert item %d: %v", i, err)
+		}
+	}
+
+	count := qf.Count()
+	estimate := qf.EstimateCardinality()
+	t.Logf("Count: %d, estimate: %d", count, estimate)
+
+	tolerance := 0.1
+	if math.Abs(float64(estimate-count)) > tolerance*float64(count) {
+		t.Errorf("Estimate %d is not within %.0f%% of the true count %d", estimate, tolerance*100, count)
+	}
+}
+
+func TestQuotientFilterChecksum(t *testing.T) {
+	items := make([][]byte, 300)
+	for i := range items {
+		items[i] = uint64ToBytes(rand.Uint64())
+	}
+
+	forward := NewQuotientFilter(9)
+	for _, item := range items {
+		forward.Insert(item)
+	}
+
+	backward := NewQuotientFilter(9)
+	for i := len(items) - 1; i >= 0; i-- {
+		backward.Insert(items[i])
+	}
+
+	if forward.Checksum() != backward.Checksum() {
+		t.Errorf("Expected equal checksums for the same items, got %016x and %016x", forward.Checksum(), backward.Checksum())
+	}
+
+	backward.Remove(items[0])
+	if forward.Checksum() == backward.Checksum() {
+		t.Error("Expected checksums to differ once the filters hold different items")
+	}
+
+	if NewQuotientFilter(9).Checksum() != 0 {
+		t.Error("Expected an empty filter to have a zero checksum")
+	}
+}
+
+func TestQuotientFilterHashAlgorithms(t *testing.T) {
+	for _, algorithm := range []HashAlgorithm{HashFNV, HashMurmur3} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			qf := NewQuotientFilter(10, WithHashAlgorithm(algorithm))
+			if qf.HashAlgorithm() != algorithm {
+				t.Fatalf("Expected hash algorithm %q, got %q", algorithm, qf.HashAlgorithm())
+			}
+
+			for i := 0; i < 500; i++ {
+				qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+			}
+			for i := 0; i < 500; i++ {
+				if exists, _ := qf.Exists([]byte(fmt.Sprintf("item%d", i))); !exists {
+					t.Errorf("item%d should exist, but doesn't", i)
+				}
+			}
+		})
+	}
+
+	if _, err := ParseHashAlgorithm("sha1"); err == nil {
+		t.Error("Expected an error for an unknown hash algorithm")
+	}
+}
+
+func TestQuotientFilterExistsWithCertainty(t *testing.T) {
+	qf := NewQuotientFilter(10)
+	qf.Insert([]byte("present"))
+
+	present, certain := qf.ExistsWithCertainty([]byte("present"))
+	if !present || certain {
+		t.Errorf("Expected an uncertain hit for an inserted item, got present=%v certain=%v", present, certain)
+	}
+
+	present, certain = qf.ExistsWithCertainty([]byte("absent"))
+	if present || !certain {
+		t.Errorf("Expected a certain miss for a missing item, got present=%v certain=%v", present, certain)
+	}
+}
+
+func TestQuotientFilterRunLengthStats(t *testing.T) {
+	qf := NewQuotientFilter(6)
+	if stats := qf.RunLengthStats(); stats != (RunLengthStats{}) {
+		t.Errorf("Expected zero stats on an empty filter, got %+v", stats)
+	}
+
+	// A cluster of three runs at quotients 10, 11 and 13 holding 5, 1 and 2
+	// entries, plus a lone entry at 40.
+	for remainder := uint64(1); remainder <= 5; remainder++ {
+		qf.insertUnsafe(10, remainder)
+	}
+	qf.insertUnsafe(11, 1)
+	qf.insertUnsafe(13, 1)
+	qf.insertUnsafe(13, 2)
+	qf.insertUnsafe(40, 1)
+
+	expected := RunLengthStats{Runs: 4, Min: 1, Max: 5, Mean: 9.0 / 4, P99: 5}
+	if stats := qf.RunLengthStats(); stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestQuotientFilterLoadFingerprints(t *testing.T) {
+	const (
+		logSize = 10
+		seed    = 42
+	)
+	qf := NewQuotientFilter(logSize, WithHashSeed(seed))
+	if qf.HashSeed() != seed {
+		t.Fatalf("Expected hash seed %d, got %d", seed, qf.HashSeed())
+	}
+
+	// Compute fingerprints the way an external pipeline would: Murmur3 with
+	// the same seed, the low logSize bits as quotient and the rest as
+	// remainder.
+	fingerprints := make([]Fingerprint, 100)
+	for i := range fingerprints {
+		hash := murmur3.Sum64WithSeed([]byte(fmt.Sprintf("item%d", i)), seed)
+		fingerprints[i] = Fingerprint{Quotient: hash & (1<<logSize - 1), Remainder: hash >> logSize}
+
+		quotient, remainder := qf.hash([]byte(fmt.Sprintf("item%d", i)))
+		if fingerprints[i] != (Fingerprint{quotient, remainder}) {
+			t.Fatalf("Fingerprint of item%d is %+v offline but %+v in the filter", i, fingerprints[i], Fingerprint{quotient, remainder})
+		}
+	}
+
+	if err := qf.LoadFingerprints(fingerprints); err != nil {
+		t.Fatalf("Failed to load fingerprints: %v", err)
+	}
+	for i := range fingerprints {
+		if exists, _ := qf.Exists([]byte(fmt.Sprintf("item%d", i))); !exists {
+			t.Errorf("item%d should exist after loading its fingerprint, but doesn't", i)
+		}
+	}
+
+	t.Run("Rejects fingerprints outside the geometry", func(t *testing.T) {
+		qf := NewQuotientFilter(logSize)
+		invalid := [][]Fingerprint{
+			{{Quotient: 1 << logSize}},
+			{{Remainder: 1 << (64 - logSize)}},
+		}
+		for _, fingerprints := range invalid {
+			if err := qf.LoadFingerprints(fingerprints); err == nil {
+				t.Errorf("Expected %+v to be rejected", fingerprints)
+			}
+		}
+		if qf.Count() != 0 {
+			t.Errorf("Expected rejected fingerprints to leave the filter empty, but found %d", qf.Count())
+		}
+	})
+}
+
+func TestQuotientFilterMaxWalk(t *testing.T) {
+	qf := NewQuotientFilter(8, WithMaxWalk(4))
+
+	// A run of 10 entries at quotient 10, far past the cap.
+	for remainder := uint64(1); remainder <= 10; remainder++ {
+		qf.insertUnsafe(10, remainder)
+	}
+
+	if exists, truncated := qf.lookupUnsafe(10, 2, qf.maxWalk); !exists || truncated {
+		t.Errorf("Expected a match within the cap, got exists=%v truncated=%v", exists, truncated)
+	}
+	if exists, truncated := qf.lookupUnsafe(10, 9, qf.maxWalk); exists || !truncated {
+		t.Errorf("Expected the walk to be truncated, got exists=%v truncated=%v", exists, truncated)
+	}
+	if qf.TruncatedLookups() != 1 {
+		t.Errorf("Expected 1 truncated lookup, got %d", qf.TruncatedLookups())
+	}
+
+	unbounded := NewQuotientFilter(8)
+	for remainder := uint64(1); remainder <= 10; remainder++ {
+		unbounded.insertUnsafe(10, remainder)
+	}
+	if exists, truncated := unbounded.lookupUnsafe(10, 9, unbounded.maxWalk); !exists || truncated {
+		t.Errorf("Expected an unbounded lookup to walk the whole run, got exists=%v truncated=%v", exists, truncated)
+	}
+}
+
+func TestQuotientFilterMaxWalkIsUncertain(t *testing.T) {
+	qf := NewQuotientFilter(4, WithMaxWalk(2), WithFIFOEviction())
+	var keys [][]byte
+	for i := 0; i < qf.Capacity(); i++ {
+		key := []byte(fmt.Sprintf("item%d", i))
+		if err := qf.Insert(key); err != nil {
+			t.Fatalf("Failed to insert %s: %v", key, err)
+		}
+		keys = append(keys, key)
+	}
+
+	truncated := 0
+	for _, key := range keys {
+		present, certain := qf.ExistsWithCertainty(key)
+		if present {
+			continue
+		}
+		truncated++
+		if certain {
+			t.Errorf("Expected a lookup of %s cut short by the cap to be uncertain", key)
+		}
+		if _, trace := qf.Trace(key); !trace.Truncated {
+			t.Errorf("Expected the trace of %s to be truncated, got %+v", key, trace)
+		}
+	}
+	if truncated == 0 {
+		t.Fatal("Expected some lookups in a full filter to hit the cap")
+	}
+
+	// Inserting keys that are already stored must not evict anything, even
+	// when a capped lookup would miss them.
+	for _, key := range keys {
+		if err := qf.Insert(key); err != nil {
+			t.Fatalf("Failed to insert %s again: %v", key, err)
+		}
+	}
+	if qf.Count() != qf.Capacity() {
+		t.Errorf("Expected re-inserting stored keys to keep %d entries, got %d", qf.Capacity(), qf.Count())
+	}
+	if err := qf.Verify(); err != nil {
+		t.Fatalf("Filter is corrupted: %v", err)
+	}
+}
+
+func TestQuotientFilterInsertBatch(t *testing.T) {
+	qf := NewQuotientFilter(4)
+	for i := 0; i < 10; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	// item9 is a duplicate, then 6 new keys fill the filter, and the rest
+	// are left out.
+	keys := [][]byte{[]byte("item9")}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("batch%d", i)))
+	}
+
+	result, err := qf.InsertBatch(keys)
+	if !errors.Is(err, ErrFilterFull) {
+		t.Fatalf("Expected ErrFilterFull, got %v", err)
+	}
+	if result.StoppedAt != 7 || result.Added != 6 {
+		t.Errorf("Expected to stop at index 7 with 6 added, got %+v", result)
+	}
+	for i, key := range keys {
+		exists, _ := qf.Exists(key)
+		if stored := i < result.StoppedAt; exists != stored {
+			t.Errorf("Expected %s to exist: %v, got %v", key, stored, exists)
+		}
+	}
+
+	qf = NewQuotientFilter(8)
+	if result, err := qf.InsertBatch(keys); err != nil || result.StoppedAt != len(keys) || result.Added != len(keys) {
+		t.Errorf("Expected the whole batch to be added, got %+v and %v", result, err)
+	}
+}
+
+func TestQuotientFilterFirstPresent(t *testing.T) {
+	qf := NewQuotientFilter(8)
+	qf.Insert([]byte("user:42:en"))
+	qf.Insert([]byte("user:42"))
+
+	keys := [][]byte{[]byte("user:42:en-GB"), []byte("user:42:en"), []byte("user:42")}
+	if index, found := qf.FirstPresent(keys); !found || index != 1 {
+		t.Errorf("Expected the first present key at index 1, got %d and %v", index, found)
+	}
+
+	if index, found := qf.FirstPresent(keys[:1]); found || index != -1 {
+		t.Errorf("Expected -1 and false when no key is present, got %d and %v", index, found)
+	}
+}
+
+func TestQuotientFilterEmptyKey(t *testing.T) {
+	qf := NewQuotientFilter(8, WithHashAlgorithm(HashMurmur3))
+	if quotient, remainder := qf.hash(nil); quotient != 0 || remainder != 0 {
+		t.Fatalf("Expected the empty key to hash to quotient 0 and remainder 0, got %d and %d", quotient, remainder)
+	}
+
+	// A zero remainder in slot 0 must not be mistaken for an empty slot, nor
+	// an empty slot for the empty key.
+	if exists, _ := qf.Exists(nil); exists {
+		t.Fatal("The empty key should not exist in an empty filter")
+	}
+	if err := qf.Insert([]byte{}); err != nil {
+		t.Fatalf("Failed to insert the empty key: %v", err)
+	}
+	qf.LoadFingerprints([]Fingerprint{{Quotient: 0, Remainder: 1}})
+
+	if exists, _ := qf.Exists(nil); !exists || qf.Count() != 2 {
+		t.Fatalf("Expected the empty key to exist next to another entry, got exists=%v and count %d", exists, qf.Count())
+	}
+	if err := qf.Verify(); err != nil {
+		t.Fatalf("Filter holding the empty key is corrupted: %v", err)
+	}
+
+	if !qf.Remove(nil) {
+		t.Fatal("Failed to remove the empty key")
+	}
+	if exists, _ := qf.Exists(nil); exists {
+		t.Error("The empty key should be gone once removed")
+	}
+	if !qf.existsUnsafe(0, 1) {
+		t.Error("Removing the empty key should leave the other entry of its run")
+	}
+	if err := qf.Verify(); err != nil {
+		t.Errorf("Filter is corrupted after removing the empty key: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// HashAlgorithm names the function used to fingerprint keys. Items hashed
+// with one algorithm cannot be found with another, so it must stay fixed for
+// the lifetime of the data.
+type HashAlgorithm string
+
+const (
+	HashFNV              HashAlgorithm = "fnv"
+	HashMurmur3          HashAlgorithm = "murmur3"
+	DefaultHashAlgorithm               = HashMurmur3
+)
+
+// Fingerprint is the slot content an item hashes to: the low logSize bits of
+// its hash select the quotient, and the remaining high bits are the
+// remainder.
+type Fingerprint struct {
+	Quotient  uint64 `json:"quotient"`
+	Remainder uint64 `json:"remainder"`
+}
+
+// ParseHashAlgorithm validates an algorithm name from the configuration.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	switch algorithm := HashAlgorithm(name); algorithm {
+	case HashFNV, HashMurmur3:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q, expected %q or %q", name, HashFNV, HashMurmur3)
+	}
+}
+
+// hasher returns the hash function for a. The seed only applies to Murmur3;
+// FNV-1a has none.
+func (a HashAlgorithm) hasher(seed uint32) func(data []byte) uint64 {
+	if a == HashFNV {
+		return fnvHash
+	}
+	return func(data []byte) uint64 {
+		return murmur3.Sum64WithSeed(data, seed)
+	}
+}
+
+func fnvHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// mix64 is the MurmurHash3 64-bit finalizer.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
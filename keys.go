@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// keyStore keeps the original keys of a key-retaining filter, grouped by
+// fingerprint. Keys are grouped by fingerprint rather than by slot because
+// entries move whenever a cluster shifts.
+type keyStore struct {
+	mu   sync.RWMutex
+	keys map[Fingerprint][]string
+}
+
+func newKeyStore() *keyStore {
+	return &keyStore{keys: make(map[Fingerprint][]string)}
+}
+
+// add records key under fp, ignoring keys that are already known.
+func (ks *keyStore) add(fp Fingerprint, key []byte) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for _, existing := range ks.keys[fp] {
+		if existing == string(key) {
+			return
+		}
+	}
+	ks.keys[fp] = append(ks.keys[fp], string(key))
+}
+
+// remove forgets key. It reports whether key was known, and whether it was
+// the last key sharing fp.
+func (ks *keyStore) remove(fp Fingerprint, key []byte) (removed bool, last bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	keys := ks.keys[fp]
+	for i, existing := range keys {
+		if existing != string(key) {
+			continue
+		}
+
+		if len(keys) == 1 {
+			delete(ks.keys, fp)
+			return true, true
+		}
+		ks.keys[fp] = append(keys[:i:i], keys[i+1:]...)
+		return true, false
+	}
+	return false, false
+}
+
+// drop forgets every key recorded under fp.
+func (ks *keyStore) drop(fp Fingerprint) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	delete(ks.keys, fp)
+}
+
+// first returns the first key recorded under fp.
+func (ks *keyStore) first(fp Fingerprint) ([]byte, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := ks.keys[fp]
+	if len(keys) == 0 {
+		return nil, false
+	}
+	return []byte(keys[0]), true
+}
+
+func (ks *keyStore) all() [][]byte {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	all := make([][]byte, 0, len(ks.keys))
+	for _, keys := range ks.keys {
+		for _, key := range keys {
+			all = append(all, []byte(key))
+		}
+	}
+	return all
+}
+
+// withPrefix returns every key starting with prefix.
+func (ks *keyStore) withPrefix(prefix string) [][]byte {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var matches [][]byte
+	for _, keys := range ks.keys {
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				matches = append(matches, []byte(key))
+			}
+		}
+	}
+	return matches
+}
+
+// regroup moves every key to the fingerprint rehash maps its current one to.
+// rehash must be injective.
+func (ks *keyStore) regroup(rehash func(Fingerprint) Fingerprint) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	regrouped := make(map[Fingerprint][]string, len(ks.keys))
+	for fp, keys := range ks.keys {
+		regrouped[rehash(fp)] = keys
+	}
+	ks.keys = regrouped
+}
+
+// replace swaps the keys for those of other, which must not be used
+// afterwards.
+func (ks *keyStore) replace(other *keyStore) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keys = other.keys
+}
+
+func (ks *keyStore) clone() *keyStore {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	clone := newKeyStore()
+	for fp, keys := range ks.keys {
+		clone.keys[fp] = append([]string(nil), keys...)
+	}
+	return clone
+}
+
+// page returns up to limit keys greater than after in byte order, and whether
+// more keys follow.
+func (ks *keyStore) page(after string, limit int) ([][]byte, bool) {
+	ks.mu.RLock()
+	sorted := make([]string, 0, len(ks.keys))
+	for _, keys := range ks.keys {
+		for _, key := range keys {
+			if key > after {
+				sorted = append(sorted, key)
+			}
+		}
+	}
+	ks.mu.RUnlock()
+
+	sort.Strings(sorted)
+	more := len(sorted) > limit
+	if more {
+		sorted = sorted[:limit]
+	}
+
+	page := make([][]byte, len(sorted))
+	for i, key := range sorted {
+		page[i] = []byte(key)
+	}
+	return page, more
+}
+
+// NewKeyRetainingQuotientFilter creates a filter that also keeps every
+// inserted key, at the cost of storing the keys in memory next to the slot
+// array. In exchange, Remove only deletes keys that were actually inserted
+// and never a different key sharing the same fingerprint, and Keys can list
+// the exact content of the filter. Count still counts distinct fingerprints.
+func NewKeyRetainingQuotientFilter(logSize uint, opts ...FilterOption) *QuotientFilter {
+	qf := NewQuotientFilter(logSize, opts...)
+	qf.keys = newKeyStore()
+	return qf
+}
+
+// RetainsKeys reports whether the filter keeps the original keys.
+func (qf *QuotientFilter) RetainsKeys() bool {
+	return qf.keys != nil
+}
+
+// Keys returns the original keys stored in a key-retaining filter, in no
+// particular order, or nil for a regular filter.
+func (qf *QuotientFilter) Keys() [][]byte {
+	if qf.keys == nil {
+		return nil
+	}
+	return qf.keys.all()
+}
+
+// KeysAfter returns up to limit original keys sorted in byte order, starting
+// after the key after, and whether more keys follow. Passing the last key of
+// a page as after resumes where that page ended, so paging is stable while
+// the filter is unchanged. It returns nil for a regular filter.
+func (qf *QuotientFilter) KeysAfter(after []byte, limit int) ([][]byte, bool) {
+	if qf.keys == nil {
+		return nil, false
+	}
+	return qf.keys.page(string(after), limit)
+}
+
+// RemoveByPrefix removes every stored key starting with prefix and returns
+// how many were removed, e.g. to invalidate a whole category of keys at once.
+// Stored keys are normalized, so prefix is compared with the normalized form.
+// Keys are removed one at a time, so a matching key inserted concurrently may
+// survive. It only works on key-retaining filters, since a regular filter
+// cannot tell which fingerprints came from keys with the prefix, and removes
+// nothing from a regular filter.
+func (qf *QuotientFilter) RemoveByPrefix(prefix []byte) int {
+	if qf.keys == nil {
+		return 0
+	}
+
+	removed := 0
+	for _, key := range qf.keys.withPrefix(string(prefix)) {
+		if ok, _ := qf.remove(context.Background(), key); ok {
+			removed++
+		}
+	}
+	return removed
+}
+
+// insertKeyUnsafe inserts the fingerprint of key, recording key itself when
+// the filter retains keys, and reports whether the fingerprint is new. The
+// caller must hold the stripe locks of its cluster.
+func (qf *QuotientFilter) insertKeyUnsafe(quotient, remainder uint64, key []byte) bool {
+	if qf.keys != nil {
+		qf.keys.add(Fingerprint{quotient, remainder}, key)
+	}
+	return qf.insertUnsafe(quotient, remainder)
+}
+
+// removeKeyUnsafe removes key. When the filter retains keys, unknown keys are
+// left alone and the fingerprint is only dropped once no other key shares it.
+// The caller must hold the stripe locks of its cluster.
+func (qf *QuotientFilter) removeKeyUnsafe(quotient, remainder uint64, key []byte) bool {
+	if qf.keys != nil {
+		removed, last := qf.keys.remove(Fingerprint{quotient, remainder}, key)
+		if !removed {
+			return false
+		}
+		if !last {
+			return true
+		}
+	}
+	return qf.removeUnsafe(quotient, remainder)
+}
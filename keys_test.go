@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestKeyRetainingFilterExactRemoval(t *testing.T) {
+	qf := NewKeyRetainingQuotientFilter(8)
+
+	// Force "first" and "second" onto the same fingerprint, as a hash
+	// collision would.
+	quotient, remainder := qf.hash([]byte("first"))
+	qf.insertKeyUnsafe(quotient, remainder, []byte("first"))
+	qf.insertKeyUnsafe(quotient, remainder, []byte("second"))
+
+	if qf.Count() != 1 {
+		t.Fatalf("Expected colliding keys to share 1 fingerprint, but found %d", qf.Count())
+	}
+
+	if qf.removeKeyUnsafe(quotient, remainder, []byte("never-inserted")) {
+		t.Error("Removing a key that was never inserted should fail, even if its fingerprint collides")
+	}
+	if !qf.existsUnsafe(quotient, remainder) {
+		t.Fatal("Fingerprint should survive the removal of an unknown colliding key")
+	}
+
+	if !qf.removeKeyUnsafe(quotient, remainder, []byte("first")) {
+		t.Fatal("Failed to remove first")
+	}
+	if !qf.existsUnsafe(quotient, remainder) {
+		t.Fatal("Fingerprint should survive while second still shares it")
+	}
+	if keys := qf.Keys(); len(keys) != 1 || string(keys[0]) != "second" {
+		t.Errorf("Expected only second to be retained, got %q", keys)
+	}
+
+	if !qf.removeKeyUnsafe(quotient, remainder, []byte("second")) {
+		t.Fatal("Failed to remove second")
+	}
+	if qf.existsUnsafe(quotient, remainder) {
+		t.Error("Fingerprint should be gone once no key shares it")
+	}
+	if qf.Count() != 0 {
+		t.Errorf("Expected an empty filter, but found %d items", qf.Count())
+	}
+}
+
+func TestKeyRetainingFilterKeys(t *testing.T) {
+	qf := NewKeyRetainingQuotientFilter(8)
+	if !qf.RetainsKeys() {
+		t.Fatal("Filter should retain keys")
+	}
+
+	for _, key := range []string{"a", "b", "c", "b"} {
+		if err := qf.Insert([]byte(key)); err != nil {
+			t.Fatalf("Failed to insert %s: %v", key, err)
+		}
+	}
+	qf.Remove([]byte("a"))
+
+	var keys []string
+	for _, key := range qf.Keys() {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+
+	if len(keys) != 2 || keys[0] != "b" || keys[1] != "c" {
+		t.Errorf("Expected keys [b c], got %q", keys)
+	}
+
+	if NewQuotientFilter(8).Keys() != nil {
+		t.Error("A regular filter should not return keys")
+	}
+}
+
+func TestKeyRetainingFilterRemoveByPrefix(t *testing.T) {
+	qf := NewKeyRetainingQuotientFilter(8)
+	for _, key := range []string{"img/a", "img/b", "img/c", "doc/a", "im"} {
+		qf.Insert([]byte(key))
+	}
+
+	if removed := qf.RemoveByPrefix([]byte("img/")); removed != 3 {
+		t.Errorf("Expected 3 keys removed, got %d", removed)
+	}
+
+	var keys []string
+	for _, key := range qf.Keys() {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "doc/a" || keys[1] != "im" {
+		t.Errorf("Expected keys [doc/a im] to be left, got %q", keys)
+	}
+	if qf.Count() != 2 {
+		t.Errorf("Expected 2 fingerprints left, got %d", qf.Count())
+	}
+
+	if removed := NewQuotientFilter(8).RemoveByPrefix([]byte("img/")); removed != 0 {
+		t.Errorf("A regular filter should not remove anything, got %d", removed)
+	}
+}
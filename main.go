@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"os"
 )
 
 var (
@@ -9,16 +14,130 @@ var (
 	QF            *QuotientFilter
 )
 
-func init() {
-	config, err := ParseConfigFile()
-	if err != nil {
-		fmt.Println(err)
+func setup(config *Config) {
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid config file: %s", err)
 	}
 
 	Configuration = config
-	QF = NewQuotientFilter(config.Quotient.LogSize)
+	ExistsLatency = NewHistogram(config.Metrics.ExistsLatencyBuckets)
+
+	var err error
+	QF, err = newFilter(config)
+	if err != nil {
+		log.Fatalf("Error creating filter: %s", err)
+	}
+
+	if config.Quotient.SelfTest {
+		if err := QF.SelfTest(); err != nil {
+			log.Fatalf("Self-test failed: %s", err)
+		}
+	}
+
+	if config.Quotient.Warmup {
+		QF.Warmup()
+	}
+}
+
+// runValidate implements the validate subcommand: it parses and validates a
+// config file without creating the filter or starting the server, writes a
+// report to out and returns the process exit code.
+func runValidate(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	path := flags.String("config", DefaultConfigFilename, "path of the config file to validate")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := ParseConfigFile(*path)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %s\n", *path, err)
+		return 1
+	}
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(out, "%s is invalid:\n%s\n", *path, err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "%s is valid\n", *path)
+	return 0
+}
+
+func newFilter(config *Config) (*QuotientFilter, error) {
+	algorithm, err := ParseHashAlgorithm(config.Quotient.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizer, err := ParseKeyNormalizer(config.Quotient.KeyNormalization)
+	if err != nil {
+		return nil, err
+	}
+
+	// A memory-mapped filter is paged in on demand and may exceed RAM.
+	if config.Quotient.MmapPath == "" {
+		if err := checkFilterMemory(config.Quotient.LogSize, config.Quotient.MaxMemoryFraction); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := []FilterOption{
+		WithHashAlgorithm(algorithm),
+		WithHashSeed(config.Quotient.HashSeed),
+		WithKeyNormalizer(normalizer),
+		WithNegativeCache(config.Quotient.NegativeCacheSize),
+		WithMaxWalk(config.Quotient.MaxWalk),
+	}
+	switch {
+	case config.Quotient.AutoStripes:
+		stripes := AutoStripeCount(config.Server.Concurrency)
+		log.Printf("Using %d lock stripes for a concurrency of %d", stripes, config.Server.Concurrency)
+		opts = append(opts, WithStripeCount(stripes))
+	case config.Quotient.Stripes > 0:
+		opts = append(opts, WithStripeCount(config.Quotient.Stripes))
+	}
+	if config.Quotient.StripeStats {
+		opts = append(opts, WithStripeStats())
+	}
+	if config.Quotient.FIFOEviction {
+		opts = append(opts, WithFIFOEviction())
+	}
+
+	switch {
+	case config.Quotient.RetainKeys && config.Quotient.MmapPath != "":
+		return nil, fmt.Errorf("retainKeys cannot be combined with mmapPath: retained keys are not persisted to the mmap file")
+	case config.Quotient.RetainKeys:
+		return NewKeyRetainingQuotientFilter(config.Quotient.LogSize, opts...), nil
+	case config.Quotient.MmapPath != "":
+		return NewMmapQuotientFilter(config.Quotient.LogSize, config.Quotient.MmapPath, opts...)
+	default:
+		return NewQuotientFilter(config.Quotient.LogSize, opts...), nil
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:], os.Stdout))
+	}
+
+	config, err := ParseConfigFile(DefaultConfigFilename)
+	if err != nil {
+		log.Fatalf("Error reading config file: %s", err)
+	}
+	setup(config)
+
+	if Configuration.Quotient.VerifyInterval > 0 {
+		go QF.RunVerifier(context.Background(), Configuration.Quotient.VerifyInterval)
+	}
+	if Configuration.Metrics.LogInterval > 0 {
+		go QF.RunStatsLogger(context.Background(), Configuration.Metrics.LogInterval)
+	}
+
 	StartServer(Configuration)
+
+	// Flushes memory-mapped filters to their file.
+	if err := QF.Close(); err != nil {
+		log.Fatalf("Error closing filter: %s", err)
+	}
 }
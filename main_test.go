@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunValidate(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.yaml")
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(good, []byte("server:\n  port: 9000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	badConfig := "quotient:\n  logSize: 60\n  hashAlgorithm: sha1\nserver:\n  port: 70000\n"
+	if err := os.WriteFile(bad, []byte(badConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if code := runValidate([]string{"--config", good}, &out); code != 0 {
+		t.Errorf("Expected exit code 0 for a valid config, got %d: %s", code, out.String())
+	}
+
+	out.Reset()
+	if code := runValidate([]string{"--config", bad}, &out); code != 1 {
+		t.Errorf("Expected exit code 1 for an invalid config, got %d", code)
+	}
+	for _, problem := range []string{"quotient.logSize", "quotient.hashAlgorithm", "server.port"} {
+		if !strings.Contains(out.String(), problem) {
+			t.Errorf("Expected the report to mention %s, got %q", problem, out.String())
+		}
+	}
+
+	out.Reset()
+	if code := runValidate([]string{"--config", filepath.Join(dir, "missing.yaml")}, &out); code != 1 {
+		t.Errorf("Expected exit code 1 for a missing config file, got %d", code)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// minRemainderBits is the fewest hash bits a slot keeps beyond the
+	// quotient. With fewer, keys sharing a quotient are barely told apart and
+	// the false positive rate climbs towards 100%.
+	minRemainderBits = 8
+
+	// maxLogSize keeps the slot array size representable and leaves
+	// minRemainderBits to the remainder.
+	maxLogSize = 64 - minRemainderBits
+
+	// minLogSize leaves a remainder narrow enough to fit a slot next to its
+	// 4 metadata bits. The remainder of a smaller filter is wider than the 60
+	// bits left, and its top bits would be dropped.
+	minLogSize = 4
+
+	// plannedLoadFactor is the highest load factor MemoryForTarget sizes a
+	// filter for. Inserts succeed until every slot is taken, but clusters,
+	// and with them every operation, grow long well before that.
+	plannedLoadFactor = 0.9
+)
+
+// checkLogSize rejects a logSize that would leave fewer than
+// minRemainderBits of the 64-bit hash to the remainder, or a remainder too
+// wide to fit a slot.
+func checkLogSize(logSize uint) error {
+	if logSize < minLogSize {
+		return fmt.Errorf("logSize %d is too small, the minimum is %d: it leaves %d of the 64 hash bits to the remainder, and a slot only has room for %d", logSize, minLogSize, 64-logSize, 64-minLogSize)
+	}
+	if logSize <= maxLogSize {
+		return nil
+	}
+
+	remainderBits := 0
+	if logSize < 64 {
+		remainderBits = 64 - int(logSize)
+	}
+	return fmt.Errorf("logSize %d is too large, the maximum is %d: it leaves %d of the 64 hash bits to the remainder, and at least %d are needed to tell keys apart", logSize, maxLogSize, remainderBits, minRemainderBits)
+}
+
+// filterBytes is the size of the slot array of a 2^logSize filter.
+func filterBytes(logSize uint) uint64 {
+	return (uint64(1) << logSize) * 8
+}
+
+// checkFilterMemory refuses a logSize whose slot array would take more than
+// fraction of the system memory, so a typo fails with an explanation instead
+// of the process being OOM-killed at startup. The check is skipped when the
+// total memory cannot be determined.
+func checkFilterMemory(logSize uint, fraction float64) error {
+	if err := checkLogSize(logSize); err != nil {
+		return err
+	}
+
+	total, ok := totalMemory()
+	if !ok {
+		return nil
+	}
+
+	required := filterBytes(logSize)
+	if limit := uint64(float64(total) * fraction); required > limit {
+		return fmt.Errorf("logSize %d requires %d bytes, more than %.0f%% of the %d bytes of system memory", logSize, required, fraction*100, total)
+	}
+	return nil
+}
+
+// MemoryForTarget plans the filter for expectedItems items with a false
+// positive rate of at most targetFPR, without creating it. It picks the
+// smallest logSize, at least minLogSize, that holds the items within
+// plannedLoadFactor, and reports the remainder width that leaves and the
+// size of the slot array. The filter always keeps the whole 64-bit hash,
+// so the remainder width follows from logSize and the rate only depends on
+// the number of items, see EstimateFalsePositiveRate: targets that no filter
+// can meet for that many items are rejected, as are item counts needing a
+// logSize past maxLogSize.
+func MemoryForTarget(expectedItems int, targetFPR float64) (logSize uint, remainderBits uint, bytes int, err error) {
+	if targetFPR <= 0 {
+		return 0, 0, 0, fmt.Errorf("target false positive rate %g must be positive", targetFPR)
+	}
+	if rate := -math.Expm1(-float64(expectedItems) / hashSpace); rate > targetFPR {
+		return 0, 0, 0, fmt.Errorf("%d items have a false positive rate of about %g, above the target of %g", expectedItems, rate, targetFPR)
+	}
+
+	logSize = minLogSize
+	for logSize < 64 && float64(expectedItems) > math.Ldexp(plannedLoadFactor, int(logSize)) {
+		logSize++
+	}
+	if err := checkLogSize(logSize); err != nil {
+		return 0, 0, 0, fmt.Errorf("%d items need a %w", expectedItems, err)
+	}
+	return logSize, 64 - logSize, int(filterBytes(logSize)), nil
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// totalMemory returns the physical memory of the machine in bytes.
+func totalMemory() (uint64, bool) {
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return 0, false
+	}
+	return uint64(info.Totalram) * uint64(info.Unit), true
+}
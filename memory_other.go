@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// totalMemory is only implemented on Linux.
+func totalMemory() (uint64, bool) {
+	return 0, false
+}
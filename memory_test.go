@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheckFilterMemory(t *testing.T) {
+	if err := checkFilterMemory(10, 0.8); err != nil {
+		t.Errorf("A small filter should be accepted, got %v", err)
+	}
+
+	if err := checkFilterMemory(64, 0.8); err == nil {
+		t.Error("A logSize past the maximum should be rejected")
+	}
+
+	if _, ok := totalMemory(); !ok {
+		t.Skip("System memory cannot be determined on this platform")
+	}
+
+	err := checkFilterMemory(52, 0.8)
+	if err == nil {
+		t.Fatal("A 32PiB filter should be rejected")
+	}
+	if !strings.Contains(err.Error(), "36028797018963968 bytes") {
+		t.Errorf("Expected the error to mention the byte requirement, got %v", err)
+	}
+}
+
+func TestCheckLogSize(t *testing.T) {
+	if err := checkLogSize(maxLogSize); err != nil {
+		t.Errorf("logSize %d should be accepted, got %v", maxLogSize, err)
+	}
+
+	for _, logSize := range []uint{maxLogSize + 1, 64, 70} {
+		err := checkLogSize(logSize)
+		if err == nil {
+			t.Errorf("logSize %d should be rejected", logSize)
+			continue
+		}
+		if !strings.Contains(err.Error(), "remainder") {
+			t.Errorf("Expected the error for logSize %d to explain the remainder width, got %v", logSize, err)
+		}
+	}
+
+	if _, err := NewQuotientFilterWithStorage(64, NewHeapStorage(8)); err == nil {
+		t.Error("Expected a filter with a zero-width remainder to be rejected")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected NewQuotientFilter to panic with a zero-width remainder")
+		}
+	}()
+	NewQuotientFilter(64)
+}
+
+func TestCheckLogSizeTooSmall(t *testing.T) {
+	for logSize := uint(0); logSize < minLogSize; logSize++ {
+		err := checkLogSize(logSize)
+		if err == nil {
+			t.Errorf("logSize %d should be rejected", logSize)
+			continue
+		}
+		if !strings.Contains(err.Error(), "too small") {
+			t.Errorf("Expected the error for logSize %d to say it is too small, got %v", logSize, err)
+		}
+
+		if _, err := NewQuotientFilterWithStorage(logSize, NewHeapStorage(logSize)); err == nil {
+			t.Errorf("Expected a filter of logSize %d to be rejected", logSize)
+		}
+
+		config := createDefaultConfig()
+		config.Quotient.LogSize = logSize
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected quotient.logSize %d to fail validation", logSize)
+		}
+	}
+
+	// The smallest filter keeps every key, even when full.
+	qf := NewQuotientFilter(minLogSize)
+	for i := 0; i < qf.Capacity(); i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+	for i := 0; i < qf.Capacity(); i++ {
+		if exists, _ := qf.Exists([]byte(fmt.Sprintf("item%d", i))); !exists {
+			t.Errorf("item%d should exist in a full filter of logSize %d, but doesn't", i, minLogSize)
+		}
+	}
+}
+
+func TestMemoryForTarget(t *testing.T) {
+	cases := []struct {
+		items         int
+		fpr           float64
+		logSize       uint
+		remainderBits uint
+		bytes         int
+	}{
+		// A million items fill 95% of 2^20 slots, so they get 2^21 slots
+		// of 8 bytes.
+		{1_000_000, 0.01, 21, 43, 8 << 21},
+		// 90% of 2^10 slots is 921.6 items.
+		{921, 1e-12, 10, 54, 8 << 10},
+		{922, 1e-12, 11, 53, 8 << 11},
+		// 90% of 2^4 slots is 14.4 items.
+		{14, 0.01, 4, 60, 8 << 4},
+		{15, 0.01, 5, 59, 8 << 5},
+		// Smaller filters cannot fit the remainder in a slot.
+		{0, 0.01, 4, 60, 8 << 4},
+	}
+	for _, c := range cases {
+		logSize, remainderBits, bytes, err := MemoryForTarget(c.items, c.fpr)
+		if err != nil {
+			t.Errorf("MemoryForTarget(%d, %g): %v", c.items, c.fpr, err)
+			continue
+		}
+		if logSize != c.logSize || remainderBits != c.remainderBits || bytes != c.bytes {
+			t.Errorf("MemoryForTarget(%d, %g): expected (%d, %d, %d), got (%d, %d, %d)",
+				c.items, c.fpr, c.logSize, c.remainderBits, c.bytes, logSize, remainderBits, bytes)
+		}
+	}
+
+	// About 1.8e7 items reach a rate of 1e-12 whatever the filter size.
+	if _, _, _, err := MemoryForTarget(20_000_000, 1e-12); err == nil {
+		t.Error("Expected an unreachable false positive rate to be rejected")
+	}
+	if _, _, _, err := MemoryForTarget(10, 0); err == nil {
+		t.Error("Expected a zero false positive rate to be rejected")
+	}
+	if _, _, _, err := MemoryForTarget(1<<60, 1); err == nil {
+		t.Error("Expected an item count past the largest filter to be rejected")
+	}
+	// 2^56 slots fit 2^56 items, but not within the planned load factor.
+	if _, _, _, err := MemoryForTarget(1<<56, 1); err == nil {
+		t.Error("Expected an item count filling the largest filter to be rejected")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ExistsLatency tracks how long QF.Exists lookups take. It is replaced at
+// startup with the buckets from the configuration.
+var ExistsLatency = NewHistogram(defaultExistsLatencyBuckets)
+
+// Insert counters, fed by every insert the server applies. Duplicates are
+// inserts of a fingerprint already in the filter, which leave it unchanged.
+var (
+	InsertAttempts   Counter
+	InsertsAdded     Counter
+	InsertsDuplicate Counter
+)
+
+// Lookup counters, fed by every lookup the server answers. On a filter that
+// should mostly miss, a rising share of hits means false positives are
+// climbing as it fills up.
+var (
+	ExistsHits   Counter
+	ExistsMisses Counter
+)
+
+// ImportMismatches counts imports rejected because the export was written
+// with other filter settings, see ErrFilterMismatch.
+var ImportMismatches Counter
+
+// Counter is a monotonically increasing count, in the style of a Prometheus
+// counter. It is safe for concurrent use.
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// WritePrometheus writes the counter in the Prometheus text exposition format.
+func (c *Counter) WritePrometheus(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "%s %d\n", name, c.Value())
+}
+
+// recordInsert updates the insert counters with the outcome of an insert.
+func recordInsert(added bool, err error) {
+	InsertAttempts.Inc()
+	switch {
+	case err != nil:
+	case added:
+		InsertsAdded.Inc()
+	default:
+		InsertsDuplicate.Inc()
+	}
+}
+
+// recordExists updates the lookup latency and counters with the outcome of a
+// lookup.
+func recordExists(exists bool, elapsed time.Duration) {
+	ExistsLatency.Observe(elapsed)
+	if exists {
+		ExistsHits.Inc()
+	} else {
+		ExistsMisses.Inc()
+	}
+}
+
+// Histogram counts durations into buckets, in the style of a Prometheus
+// histogram. It is safe for concurrent use.
+type Histogram struct {
+	bounds []time.Duration // Ascending upper bounds of the buckets
+	counts []atomic.Uint64 // One per bound, plus a final +Inf bucket
+	sum    atomic.Int64    // Total of all observations, in nanoseconds
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds.
+// Bounds are sorted and deduplicated; non-positive bounds are dropped.
+func NewHistogram(bounds []time.Duration) *Histogram {
+	sorted := make([]time.Duration, 0, len(bounds))
+	for _, bound := range bounds {
+		if bound > 0 {
+			sorted = append(sorted, bound)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	unique := sorted[:0]
+	for i, bound := range sorted {
+		if i == 0 || bound != sorted[i-1] {
+			unique = append(unique, bound)
+		}
+	}
+
+	return &Histogram{
+		bounds: unique,
+		counts: make([]atomic.Uint64, len(unique)+1),
+	}
+}
+
+// Observe records a single duration.
+func (h *Histogram) Observe(d time.Duration) {
+	bucket := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	h.counts[bucket].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// WritePrometheus writes the histogram in the Prometheus text exposition format,
+// with cumulative bucket counts and values in seconds.
+func (h *Histogram) WritePrometheus(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatSeconds(bound), cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatSeconds(time.Duration(h.sum.Load())))
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// WriteMetrics writes every metric exposed by the server.
+func WriteMetrics(w io.Writer) {
+	ExistsLatency.WritePrometheus(w, "quotient_exists_duration_seconds", "Time spent looking up a key in the filter.")
+	ExistsHits.WritePrometheus(w, "quotient_exists_hit_total", "Lookups answering that the key may exist.")
+	ExistsMisses.WritePrometheus(w, "quotient_exists_miss_total", "Lookups answering that the key does not exist.")
+	InsertAttempts.WritePrometheus(w, "quotient_insert_attempts_total", "Inserts attempted, including failed ones.")
+	InsertsAdded.WritePrometheus(w, "quotient_inserts_added_total", "Inserts that added a new fingerprint to the filter.")
+	InsertsDuplicate.WritePrometheus(w, "quotient_inserts_duplicate_total", "Inserts of a fingerprint already in the filter.")
+	ImportMismatches.WritePrometheus(w, "quotient_import_mismatch_total", "Imports rejected for another logSize, hash algorithm or hash seed.")
+
+	if QF != nil {
+		fmt.Fprintf(w, "# HELP quotient_exists_truncated_total Lookups that gave up after walking quotient.maxWalk slots.\n")
+		fmt.Fprintf(w, "# TYPE quotient_exists_truncated_total counter\n")
+		fmt.Fprintf(w, "quotient_exists_truncated_total %d\n", QF.TruncatedLookups())
+	}
+}
+
+// RunStatsLogger logs a line with the size and run lengths of the filter
+// every interval until ctx is done, for deployments without Prometheus.
+// Measuring run lengths scans the whole filter under the stripe read locks,
+// so the interval should be generous on large filters.
+func (qf *QuotientFilter) RunStatsLogger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runs := qf.RunLengthStats()
+			log.Printf("stats count=%d capacity=%d load_factor=%.4f runs=%d max_run=%d p99_run=%d",
+				qf.Count(), qf.Capacity(), qf.LoadFactor(), runs.Runs, runs.Max, runs.P99)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	h := NewHistogram([]time.Duration{100 * time.Microsecond, time.Microsecond, 10 * time.Microsecond, time.Microsecond})
+
+	for _, d := range []time.Duration{
+		500 * time.Nanosecond,
+		time.Microsecond,
+		5 * time.Microsecond,
+		50 * time.Microsecond,
+		time.Millisecond,
+	} {
+		h.Observe(d)
+	}
+
+	var out bytes.Buffer
+	h.WritePrometheus(&out, "test_duration_seconds", "Test durations.")
+
+	for _, line := range []string{
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{le="1e-06"} 2`,
+		`test_duration_seconds_bucket{le="1e-05"} 3`,
+		`test_duration_seconds_bucket{le="0.0001"} 4`,
+		`test_duration_seconds_bucket{le="+Inf"} 5`,
+		"test_duration_seconds_sum 0.0010565",
+		"test_duration_seconds_count 5",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestInsertCounters(t *testing.T) {
+	useTestFilter(t, 8)
+	attempts, added, duplicates := InsertAttempts.Value(), InsertsAdded.Value(), InsertsDuplicate.Value()
+
+	for i := 0; i < 2; i++ {
+		ctx := newTestRequestCtx("POST", "/v1/insert", []byte(`{"key":"twice"}`))
+		v1InsertHandler(ctx)
+	}
+
+	if got := InsertAttempts.Value() - attempts; got != 2 {
+		t.Errorf("Expected 2 insert attempts, got %d", got)
+	}
+	if got := InsertsAdded.Value() - added; got != 1 {
+		t.Errorf("Expected 1 added insert, got %d", got)
+	}
+	if got := InsertsDuplicate.Value() - duplicates; got != 1 {
+		t.Errorf("Expected 1 duplicate insert, got %d", got)
+	}
+
+	var out bytes.Buffer
+	WriteMetrics(&out)
+	for _, line := range []string{
+		"# TYPE quotient_insert_attempts_total counter",
+		"quotient_inserts_duplicate_total ",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestExistsCounters(t *testing.T) {
+	qf := useTestFilter(t, 8)
+	qf.Insert([]byte("present"))
+	hits, misses := ExistsHits.Value(), ExistsMisses.Value()
+
+	v1ExistsHandler(newTestRequestCtx("GET", "/v1/exists?key=present", nil))
+	if got := ExistsHits.Value() - hits; got != 1 {
+		t.Errorf("Expected 1 hit after looking up a present key, got %d", got)
+	}
+
+	v1ExistsHandler(newTestRequestCtx("GET", "/v1/exists?key=absent", nil))
+	if got := ExistsMisses.Value() - misses; got != 1 {
+		t.Errorf("Expected 1 miss after looking up an absent key, got %d", got)
+	}
+	if got := ExistsHits.Value() - hits; got != 1 {
+		t.Errorf("Expected the miss to leave hits at 1, got %d", got)
+	}
+
+	var out bytes.Buffer
+	WriteMetrics(&out)
+	for _, line := range []string{"quotient_exists_hit_total ", "quotient_exists_miss_total "} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestRunStatsLogger(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	qf := NewQuotientFilter(8)
+	qf.Insert([]byte("alpha"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		qf.RunStatsLogger(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if !strings.Contains(output.String(), "stats count=1 capacity=256 ") {
+		t.Errorf("Expected at least one stats line, got %q", output.String())
+	}
+}
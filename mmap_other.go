@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// NewMmapQuotientFilter is only supported on unix systems.
+func NewMmapQuotientFilter(logSize uint, path string, opts ...FilterOption) (*QuotientFilter, error) {
+	return nil, fmt.Errorf("memory-mapped filters are not supported on %s", runtime.GOOS)
+}
+
+// NewAnonymousMmapStorage is only supported on unix systems.
+func NewAnonymousMmapStorage(logSize uint) (SlotStorage, error) {
+	return nil, fmt.Errorf("memory-mapped storage is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,115 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The mmap file starts with the filter header, padded to a page, followed by
+// the slot array.
+const mmapHeaderSize = 4096
+
+// NewMmapQuotientFilter creates a filter whose slot array is memory-mapped
+// from the file at path. The content survives restarts without a separate
+// snapshot, and the OS page cache decides which slots stay resident, so the
+// filter may exceed the available RAM. A new or empty file is grown to hold
+// 2^logSize slots; a file written with another geometry, hash algorithm or
+// seed is rejected. Call Close to flush and unmap the file.
+func NewMmapQuotientFilter(logSize uint, path string, opts ...FilterOption) (*QuotientFilter, error) {
+	if err := checkLogSize(logSize); err != nil {
+		return nil, err
+	}
+
+	size := uint64(1) << logSize
+	length := int64(mmapHeaderSize + size*8)
+	qf := newQuotientFilter(nil, logSize, opts)
+	if qf.fifo != nil {
+		return nil, errors.New("FIFO eviction is not supported on memory-mapped filters: the insertion order is not persisted")
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open mmap file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not stat mmap file: %w", err)
+	}
+
+	fresh := info.Size() == 0
+	switch info.Size() {
+	case length:
+	case 0:
+		if err := file.Truncate(length); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("could not grow mmap file to %d bytes: %w", length, err)
+		}
+	default:
+		file.Close()
+		return nil, fmt.Errorf("mmap file %s holds %d bytes, expected %d for logSize %d", path, info.Size(), length, logSize)
+	}
+
+	mapping, err := syscall.Mmap(int(file.Fd()), 0, int(length), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not mmap file: %w", err)
+	}
+
+	if fresh {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, newFilterHeader(qf))
+		copy(mapping, buf.Bytes())
+	} else {
+		var header filterHeader
+		if err = binary.Read(bytes.NewReader(mapping[:mmapHeaderSize]), binary.LittleEndian, &header); err == nil {
+			err = header.check(qf)
+		}
+	}
+	if err != nil {
+		syscall.Munmap(mapping)
+		file.Close()
+		return nil, fmt.Errorf("mmap file %s: %w", path, err)
+	}
+
+	qf.data = unsafe.Slice((*uint64)(unsafe.Pointer(&mapping[mmapHeaderSize])), size)
+	qf.count.Store(qf.countEntries())
+
+	qf.release = func() error {
+		qf.data = nil
+		// fsync flushes the dirty pages of the shared mapping, which stay in
+		// the page cache after munmap.
+		return errors.Join(syscall.Munmap(mapping), file.Sync(), file.Close())
+	}
+
+	return qf, nil
+}
+
+type anonymousMmapStorage []byte
+
+// NewAnonymousMmapStorage maps 2^logSize zeroed slots outside of the Go heap,
+// so that a large filter adds nothing to the garbage collector's work and its
+// memory is given back to the OS as soon as it is closed.
+func NewAnonymousMmapStorage(logSize uint) (SlotStorage, error) {
+	mapping, err := syscall.Mmap(-1, 0, int(filterBytes(logSize)), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("could not mmap %d bytes: %w", filterBytes(logSize), err)
+	}
+	return anonymousMmapStorage(mapping), nil
+}
+
+func (s anonymousMmapStorage) Slots() []uint64 {
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&s[0])), len(s)/8)
+}
+
+func (s anonymousMmapStorage) Close() error {
+	return syscall.Munmap(s)
+}
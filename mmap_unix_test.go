@@ -0,0 +1,113 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMmapQuotientFilterSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.qf")
+
+	qf, err := NewMmapQuotientFilter(10, path)
+	if err != nil {
+		t.Fatalf("Failed to create mmap filter: %v", err)
+	}
+
+	for i := 0; i < 500; i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+	qf.Remove([]byte("item0"))
+
+	if err := qf.Close(); err != nil {
+		t.Fatalf("Failed to close mmap filter: %v", err)
+	}
+
+	restored, err := NewMmapQuotientFilter(10, path)
+	if err != nil {
+		t.Fatalf("Failed to remap filter: %v", err)
+	}
+	defer restored.Close()
+
+	if restored.Count() != 499 {
+		t.Errorf("Expected 499 items after remapping, but found %d", restored.Count())
+	}
+	for i := 1; i < 500; i++ {
+		if exists, _ := restored.Exists([]byte(fmt.Sprintf("item%d", i))); !exists {
+			t.Errorf("item%d should exist after remapping, but doesn't", i)
+		}
+	}
+	if err := restored.Verify(); err != nil {
+		t.Errorf("Remapped filter failed verification: %v", err)
+	}
+}
+
+func TestMmapQuotientFilterRejectsGeometryMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.qf")
+
+	qf, err := NewMmapQuotientFilter(10, path)
+	if err != nil {
+		t.Fatalf("Failed to create mmap filter: %v", err)
+	}
+	qf.Close()
+
+	_, err = NewMmapQuotientFilter(12, path)
+	if err == nil || !strings.Contains(err.Error(), "expected") {
+		t.Fatalf("Expected a geometry mismatch error, got %v", err)
+	}
+}
+
+func TestMmapQuotientFilterRejectsHashAlgorithmMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.qf")
+
+	qf, err := NewMmapQuotientFilter(10, path, WithHashAlgorithm(HashFNV))
+	if err != nil {
+		t.Fatalf("Failed to create mmap filter: %v", err)
+	}
+	qf.Insert([]byte("item"))
+	qf.Close()
+
+	_, err = NewMmapQuotientFilter(10, path, WithHashAlgorithm(HashMurmur3))
+	if err == nil || !strings.Contains(err.Error(), "hash algorithm") {
+		t.Fatalf("Expected a hash algorithm mismatch error, got %v", err)
+	}
+
+	// FNV-1a ignores the seed, so another seed maps the same slots.
+	reseeded, err := NewMmapQuotientFilter(10, path, WithHashAlgorithm(HashFNV), WithHashSeed(7))
+	if err != nil {
+		t.Fatalf("Expected the seed to be ignored with FNV-1a, got %v", err)
+	}
+	if exists, _ := reseeded.Exists([]byte("item")); !exists {
+		t.Error("item should exist under another FNV-1a seed, but doesn't")
+	}
+	reseeded.Close()
+
+	restored, err := NewMmapQuotientFilter(10, path, WithHashAlgorithm(HashFNV))
+	if err != nil {
+		t.Fatalf("Failed to remap filter with its original algorithm: %v", err)
+	}
+	defer restored.Close()
+
+	if exists, _ := restored.Exists([]byte("item")); !exists {
+		t.Error("item should exist after remapping, but doesn't")
+	}
+}
+
+func TestQuotientFilterWithAnonymousMmapStorage(t *testing.T) {
+	storage, err := NewAnonymousMmapStorage(10)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	qf, err := NewQuotientFilterWithStorage(10, storage)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	defer qf.Close()
+
+	testFilterCorrectness(t, qf)
+}
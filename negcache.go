@@ -0,0 +1,128 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// negativeCache remembers keys recently found absent, so repeated lookups of
+// the same missing keys skip the hash and the slot walk. Only absent answers
+// are cached: they stay true until an insert lands on the key's quotient,
+// which invalidates every cached key of that quotient, whereas a cached
+// positive would go stale on the next Remove.
+type negativeCache struct {
+	mu         sync.Mutex
+	capacity   int
+	order      *list.List // Front is the most recently used
+	entries    map[string]*list.Element
+	byQuotient map[uint64][]*list.Element
+	hits       atomic.Uint64
+}
+
+type negativeCacheEntry struct {
+	key      string
+	quotient uint64
+}
+
+func newNegativeCache(capacity int) *negativeCache {
+	return &negativeCache{
+		capacity:   capacity,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		byQuotient: make(map[uint64][]*list.Element),
+	}
+}
+
+// contains reports whether key is cached as absent.
+func (nc *negativeCache) contains(key []byte) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	element, ok := nc.entries[string(key)]
+	if !ok {
+		return false
+	}
+	nc.order.MoveToFront(element)
+	nc.hits.Add(1)
+	return true
+}
+
+// add caches key as absent, evicting the least recently used key when full.
+// The caller must hold the read lock of the stripe owning quotient, so that
+// no insert can slip in between the lookup and the caching.
+func (nc *negativeCache) add(key []byte, quotient uint64) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if _, ok := nc.entries[string(key)]; ok {
+		return
+	}
+	if nc.order.Len() >= nc.capacity {
+		nc.removeElement(nc.order.Back())
+	}
+
+	element := nc.order.PushFront(negativeCacheEntry{key: string(key), quotient: quotient})
+	nc.entries[string(key)] = element
+	nc.byQuotient[quotient] = append(nc.byQuotient[quotient], element)
+}
+
+// invalidate forgets every key of quotient.
+func (nc *negativeCache) invalidate(quotient uint64) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	for _, element := range nc.byQuotient[quotient] {
+		nc.order.Remove(element)
+		delete(nc.entries, element.Value.(negativeCacheEntry).key)
+	}
+	delete(nc.byQuotient, quotient)
+}
+
+// clear forgets every key.
+func (nc *negativeCache) clear() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.order.Init()
+	nc.entries = make(map[string]*list.Element)
+	nc.byQuotient = make(map[uint64][]*list.Element)
+}
+
+func (nc *negativeCache) removeElement(element *list.Element) {
+	entry := nc.order.Remove(element).(negativeCacheEntry)
+	delete(nc.entries, entry.key)
+
+	siblings := nc.byQuotient[entry.quotient]
+	for i, sibling := range siblings {
+		if sibling == element {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(nc.byQuotient, entry.quotient)
+	} else {
+		nc.byQuotient[entry.quotient] = siblings
+	}
+}
+
+// WithNegativeCache puts an LRU cache of up to size recently absent keys in
+// front of Exists. It is meant for workloads that keep asking for the same
+// missing keys; a size of zero disables it.
+func WithNegativeCache(size int) FilterOption {
+	return func(qf *QuotientFilter) {
+		if size > 0 {
+			qf.negatives = newNegativeCache(size)
+		}
+	}
+}
+
+// NegativeCacheHits returns how many lookups were answered by the negative
+// cache.
+func (qf *QuotientFilter) NegativeCacheHits() uint64 {
+	if qf.negatives == nil {
+		return 0
+	}
+	return qf.negatives.hits.Load()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQuotientFilterNegativeCache(t *testing.T) {
+	qf := NewQuotientFilter(10, WithNegativeCache(4))
+
+	for i := 0; i < 3; i++ {
+		if exists, _ := qf.Exists([]byte("absent")); exists {
+			t.Fatal("absent should not exist")
+		}
+	}
+	if hits := qf.NegativeCacheHits(); hits != 2 {
+		t.Errorf("Expected repeated lookups to hit the cache twice, got %d hits", hits)
+	}
+
+	t.Run("Insert invalidates the quotient", func(t *testing.T) {
+		qf.Insert([]byte("absent"))
+		if exists, _ := qf.Exists([]byte("absent")); !exists {
+			t.Error("absent should exist after being inserted, but the cache answered")
+		}
+		if hits := qf.NegativeCacheHits(); hits != 2 {
+			t.Errorf("Expected no new cache hits after the insert, got %d", hits)
+		}
+	})
+
+	t.Run("Evicts the least recently used key", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			qf.Exists([]byte(fmt.Sprintf("missing%d", i)))
+		}
+		before := qf.NegativeCacheHits()
+		qf.Exists([]byte("missing0"))
+		if qf.NegativeCacheHits() != before {
+			t.Error("missing0 should have been evicted")
+		}
+		qf.Exists([]byte("missing4"))
+		if qf.NegativeCacheHits() != before+1 {
+			t.Error("missing4 should still be cached")
+		}
+	})
+
+	if NewQuotientFilter(10).negatives != nil {
+		t.Error("The negative cache should be disabled by default")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// KeyNormalizer rewrites a key before it is hashed, so that spellings the
+// application considers equal share a fingerprint. Changing the normalizer of
+// a filter that already holds items makes some of them unreachable.
+type KeyNormalizer func(key []byte) []byte
+
+var keyNormalizationSteps = map[string]KeyNormalizer{
+	"lowercase": bytes.ToLower,
+	"trim":      bytes.TrimSpace,
+}
+
+// ParseKeyNormalizer builds a normalizer applying the named steps in order.
+// The known steps are "lowercase" and "trim". No steps means no normalizer.
+func ParseKeyNormalizer(steps []string) (KeyNormalizer, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	normalizers := make([]KeyNormalizer, len(steps))
+	for i, step := range steps {
+		normalizer, ok := keyNormalizationSteps[step]
+		if !ok {
+			return nil, fmt.Errorf("unknown key normalization step %q, expected \"lowercase\" or \"trim\"", step)
+		}
+		normalizers[i] = normalizer
+	}
+
+	return func(key []byte) []byte {
+		for _, normalizer := range normalizers {
+			key = normalizer(key)
+		}
+		return key
+	}, nil
+}
+
+// WithKeyNormalizer normalizes every key given to Insert, Exists, Trace and
+// Remove before it is hashed or retained.
+func WithKeyNormalizer(normalizer KeyNormalizer) FilterOption {
+	return func(qf *QuotientFilter) {
+		qf.normalizer = normalizer
+	}
+}
+
+func (qf *QuotientFilter) normalize(key []byte) []byte {
+	if qf.normalizer == nil {
+		return key
+	}
+	return qf.normalizer(key)
+}
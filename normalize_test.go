@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestQuotientFilterKeyNormalization(t *testing.T) {
+	normalizer, err := ParseKeyNormalizer([]string{"lowercase", "trim"})
+	if err != nil {
+		t.Fatalf("Failed to parse normalizer: %v", err)
+	}
+
+	normalized := NewKeyRetainingQuotientFilter(10, WithKeyNormalizer(normalizer))
+	plain := NewKeyRetainingQuotientFilter(10)
+	for _, qf := range []*QuotientFilter{normalized, plain} {
+		qf.Insert([]byte("Foo@X.com"))
+	}
+
+	if exists, _ := normalized.Exists([]byte("  foo@x.com ")); !exists {
+		t.Error("Differently-cased keys should collide with normalization on")
+	}
+	if exists, _ := plain.Exists([]byte("foo@x.com")); exists {
+		t.Error("Differently-cased keys should not collide with normalization off")
+	}
+
+	if !normalized.Remove([]byte("FOO@x.COM")) {
+		t.Error("Remove should normalize the key like Insert")
+	}
+	if normalized.Count() != 0 || len(normalized.Keys()) != 0 {
+		t.Errorf("Expected an empty filter after removing the key, got %d items", normalized.Count())
+	}
+
+	if _, err := ParseKeyNormalizer([]string{"uppercase"}); err == nil {
+		t.Error("Expected an error for an unknown normalization step")
+	}
+}
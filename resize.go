@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Resize grows the filter to 2^logSize slots without losing items. A stored
+// quotient and remainder together hold the whole hash of an item, so every
+// entry is re-split under the new geometry and reinserted. The result only
+// depends on the stored entries, not on insertion order, so filters holding
+// the same items end up identical. Shrinking is refused, since it would have
+// to drop hash bits. It holds every stripe lock while rebuilding, and a
+// memory-mapped filter or one built on a SlotStorage cannot be resized.
+func (qf *QuotientFilter) Resize(logSize uint) error {
+	if qf.release != nil {
+		return errors.New("cannot resize a memory-mapped filter or one with custom storage: it is sized for the current logSize")
+	}
+	if err := checkLogSize(logSize); err != nil {
+		return err
+	}
+
+	qf.lockAll()
+	defer qf.unlockAll()
+
+	if logSize <= qf.quotient {
+		return fmt.Errorf("cannot resize from logSize %d to %d: filters can only grow", qf.quotient, logSize)
+	}
+
+	resized := newQuotientFilter(make([]uint64, uint64(1)<<logSize), logSize, nil)
+	rehash := func(fp Fingerprint) Fingerprint {
+		quotient, remainder := resized.split(fp.Remainder<<qf.quotient | fp.Quotient)
+		return Fingerprint{quotient, remainder}
+	}
+
+	// Runs keep their insertion order, so entries are reinserted sorted to
+	// make the new layout depend on the stored items alone.
+	fingerprints := make([]Fingerprint, 0, qf.count.Load())
+	qf.forEachEntry(func(quotient, remainder uint64) {
+		fingerprints = append(fingerprints, rehash(Fingerprint{quotient, remainder}))
+	})
+	sort.Slice(fingerprints, func(i, j int) bool {
+		if fingerprints[i].Quotient != fingerprints[j].Quotient {
+			return fingerprints[i].Quotient < fingerprints[j].Quotient
+		}
+		return fingerprints[i].Remainder < fingerprints[j].Remainder
+	})
+	for _, fp := range fingerprints {
+		resized.insertUnsafe(fp.Quotient, fp.Remainder)
+	}
+	if qf.keys != nil {
+		qf.keys.regroup(rehash)
+	}
+	if qf.fifo != nil {
+		qf.fifo.regroup(rehash)
+	}
+
+	qf.data, qf.mask, qf.quotient = resized.data, resized.mask, resized.quotient
+	qf.count.Store(resized.count.Load())
+	if qf.negatives != nil {
+		qf.negatives.clear()
+	}
+	qf.audit.RecordFilter("resize")
+	return nil
+}
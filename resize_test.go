@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestQuotientFilterResize(t *testing.T) {
+	keys := make([][]byte, 700)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("item%d", i))
+	}
+
+	// Two nodes holding the same items, inserted in different orders.
+	first := NewQuotientFilter(10)
+	second := NewQuotientFilter(10)
+	for _, i := range rand.Perm(len(keys)) {
+		first.Insert(keys[i])
+	}
+	for _, i := range rand.Perm(len(keys)) {
+		second.Insert(keys[i])
+	}
+	checksum := first.Checksum()
+
+	for _, qf := range []*QuotientFilter{first, second} {
+		if err := qf.Resize(12); err != nil {
+			t.Fatalf("Failed to resize filter: %v", err)
+		}
+	}
+
+	if first.Capacity() != 1<<12 {
+		t.Errorf("Expected capacity %d after resizing, got %d", 1<<12, first.Capacity())
+	}
+	if first.Count() != second.Count() || first.Checksum() != checksum {
+		t.Errorf("Expected resizing to keep the same items, got count %d and checksum %x", first.Count(), first.Checksum())
+	}
+	if !reflect.DeepEqual(first.data, second.data) {
+		t.Error("Filters resized from the same items should be identical")
+	}
+	if err := first.Verify(); err != nil {
+		t.Errorf("Resized filter failed verification: %v", err)
+	}
+	for _, key := range keys {
+		if exists, _ := first.Exists(key); !exists {
+			t.Errorf("%s should exist after resizing, but doesn't", key)
+		}
+	}
+
+	if err := first.Resize(11); err == nil {
+		t.Error("Expected shrinking to be refused")
+	}
+}
+
+func TestKeyRetainingQuotientFilterResize(t *testing.T) {
+	qf := NewKeyRetainingQuotientFilter(8)
+	for i := 0; i < 100; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	if err := qf.Resize(10); err != nil {
+		t.Fatalf("Failed to resize filter: %v", err)
+	}
+
+	if !qf.Remove([]byte("item42")) {
+		t.Error("Expected a retained key to be removable after resizing")
+	}
+	if exists, _ := qf.Exists([]byte("item42")); exists {
+		t.Error("item42 should not exist after being removed")
+	}
+	if len(qf.Keys()) != 99 {
+		t.Errorf("Expected 99 retained keys, got %d", len(qf.Keys()))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import "math/rand"
+
+// sampleProbeFactor bounds the random probes of Sample to this many times
+// the number expected to find k entries.
+const sampleProbeFactor = 16
+
+// Sample returns up to k distinct stored entries, chosen uniformly at random
+// without replacement. Every entry sits in exactly one slot, so picking
+// slots uniformly and skipping empty or already picked ones gives each
+// remaining entry the same chance. When k is a sizeable share of the
+// entries, probing would keep hitting picks, so the whole filter is scanned
+// with reservoir sampling instead, which has the same distribution. Probing
+// gives up after sampleProbeFactor times the expected number of probes, so
+// on a vanishingly unlucky run fewer than k entries are returned. It holds
+// every stripe read lock while sampling.
+func (qf *QuotientFilter) Sample(k int) []Fingerprint {
+	qf.rLockAll()
+	defer qf.rUnlockAll()
+
+	count := int(qf.count.Load())
+	if k <= 0 || count == 0 {
+		return nil
+	}
+	// A full filter may have no cluster start for entryQuotient to walk
+	// back to.
+	if 4*k >= count || count == len(qf.data) {
+		return qf.sampleScanUnsafe(k)
+	}
+	return qf.sampleProbeUnsafe(k, count)
+}
+
+// sampleScanUnsafe reservoir-samples k entries over a full scan. The caller
+// must hold every stripe lock.
+func (qf *QuotientFilter) sampleScanUnsafe(k int) []Fingerprint {
+	sample := make([]Fingerprint, 0, k)
+	seen := 0
+	qf.forEachEntry(func(quotient, remainder uint64) {
+		seen++
+		if len(sample) < k {
+			sample = append(sample, Fingerprint{quotient, remainder})
+		} else if i := rand.Intn(seen); i < k {
+			sample[i] = Fingerprint{quotient, remainder}
+		}
+	})
+	return sample
+}
+
+// sampleProbeUnsafe picks k entries by probing random slots. The caller must
+// hold every stripe lock.
+func (qf *QuotientFilter) sampleProbeUnsafe(k, count int) []Fingerprint {
+	sample := make([]Fingerprint, 0, k)
+	picked := make(map[uint64]bool, k)
+	probes := sampleProbeFactor * k * len(qf.data) / count
+	for ; probes > 0 && len(sample) < k; probes-- {
+		slot := rand.Uint64() & qf.mask
+		if qf.isEmpty(slot) || picked[slot] {
+			continue
+		}
+
+		picked[slot] = true
+		sample = append(sample, Fingerprint{qf.entryQuotient(slot), qf.getRemainder(slot)})
+	}
+	return sample
+}
+
+// entryQuotient returns the quotient of the entry stored in slot, by walking
+// back to the start of its cluster and replaying the runs up to slot, as
+// forEachEntry does. The slot must not be empty.
+func (qf *QuotientFilter) entryQuotient(slot uint64) uint64 {
+	clusterStart := slot
+	for qf.isShifted(clusterStart) {
+		clusterStart = (clusterStart - 1) & qf.mask
+	}
+
+	runQuotient := clusterStart
+	for current := (clusterStart + 1) & qf.mask; current != (slot+1)&qf.mask; current = (current + 1) & qf.mask {
+		if qf.isRunStart(current) {
+			runQuotient = qf.nextOccupied(runQuotient)
+		}
+	}
+	return runQuotient
+}
+
+// SampleKeys is the key-retaining counterpart of Sample: it returns one of
+// the original keys of up to k entries chosen by Sample, or nil when the
+// filter does not retain keys.
+func (qf *QuotientFilter) SampleKeys(k int) [][]byte {
+	if qf.keys == nil {
+		return nil
+	}
+
+	sample := qf.Sample(k)
+	keys := make([][]byte, 0, len(sample))
+	for _, fp := range sample {
+		if key, ok := qf.keys.first(fp); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQuotientFilterSample(t *testing.T) {
+	qf := NewQuotientFilter(8)
+	for i := 0; i < 160; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	population := make(map[Fingerprint]int)
+	qf.forEachEntry(func(quotient, remainder uint64) {
+		population[Fingerprint{quotient, remainder}] = 0
+	})
+
+	// k = 8 probes random slots, k = 64 scans the whole filter.
+	for _, k := range []int{8, 64} {
+		for fp := range population {
+			population[fp] = 0
+		}
+
+		const trials = 2000
+		for trial := 0; trial < trials; trial++ {
+			sample := qf.Sample(k)
+			if len(sample) != k {
+				t.Fatalf("k=%d: expected %d entries, got %d", k, k, len(sample))
+			}
+
+			seen := make(map[Fingerprint]bool, k)
+			for _, fp := range sample {
+				if _, ok := population[fp]; !ok {
+					t.Fatalf("k=%d: sampled %+v, which is not stored", k, fp)
+				}
+				if seen[fp] {
+					t.Fatalf("k=%d: sampled %+v twice", k, fp)
+				}
+				seen[fp] = true
+				population[fp]++
+			}
+		}
+
+		expected := float64(trials*k) / float64(len(population))
+		for fp, hits := range population {
+			if float64(hits) < expected/2 || float64(hits) > expected*3/2 {
+				t.Errorf("k=%d: %+v was sampled %d times, expected about %.0f", k, fp, hits, expected)
+			}
+		}
+	}
+
+	if sample := qf.Sample(1000); len(sample) != len(population) {
+		t.Errorf("Expected a sample larger than the filter to return every entry, got %d", len(sample))
+	}
+	if sample := NewQuotientFilter(8).Sample(5); len(sample) != 0 {
+		t.Errorf("Expected no entries from an empty filter, got %d", len(sample))
+	}
+}
+
+func TestQuotientFilterSampleKeys(t *testing.T) {
+	if keys := NewQuotientFilter(8).SampleKeys(3); keys != nil {
+		t.Errorf("Expected no keys from a filter that does not retain them, got %q", keys)
+	}
+
+	qf := NewKeyRetainingQuotientFilter(8)
+	stored := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		qf.Insert([]byte(key))
+		stored[key] = true
+	}
+
+	keys := qf.SampleKeys(10)
+	if len(keys) != 10 {
+		t.Fatalf("Expected 10 keys, got %d", len(keys))
+	}
+	for _, key := range keys {
+		if !stored[string(key)] {
+			t.Errorf("Sampled %q, which was never inserted", key)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	selfTestLogSize  = 8 // Size of the scratch filter used by SelfTest
+	selfTestCanaries = 16
+)
+
+// canaryFilter is the part of a filter exercised by runCanaries.
+type canaryFilter interface {
+	Insert(data []byte) error
+	Exists(data []byte) (bool, time.Duration)
+	Remove(data []byte) bool
+}
+
+// SelfTest checks that a scratch filter with the same hash settings as qf
+// stores and forgets canary keys, to catch a broken build before serving.
+// A memory-mapped filter, whose slots were restored from disk, also goes
+// through Verify.
+func (qf *QuotientFilter) SelfTest() error {
+	scratch := newQuotientFilter(make([]uint64, 1<<selfTestLogSize), selfTestLogSize, []FilterOption{
+		WithHashAlgorithm(qf.algorithm),
+		WithHashSeed(qf.seed),
+		WithKeyNormalizer(qf.normalizer),
+	})
+	if err := runCanaries(scratch); err != nil {
+		return err
+	}
+
+	if qf.release != nil {
+		if err := qf.Verify(); err != nil {
+			return fmt.Errorf("restored filter is corrupted: %w", err)
+		}
+	}
+	return nil
+}
+
+// runCanaries inserts canary keys into f, which must not hold them yet, and
+// checks that they are found, that keys never inserted are not, and that
+// removed canaries are gone.
+func runCanaries(f canaryFilter) error {
+	for i := 0; i < selfTestCanaries; i++ {
+		canary := []byte(fmt.Sprintf("quotient-canary-%d", i))
+		if err := f.Insert(canary); err != nil {
+			return fmt.Errorf("could not insert canary %q: %w", canary, err)
+		}
+	}
+
+	for i := 0; i < selfTestCanaries; i++ {
+		canary := []byte(fmt.Sprintf("quotient-canary-%d", i))
+		if exists, _ := f.Exists(canary); !exists {
+			return fmt.Errorf("canary %q was inserted but is not found", canary)
+		}
+
+		absent := []byte(fmt.Sprintf("quotient-absent-%d", i))
+		if exists, _ := f.Exists(absent); exists {
+			return fmt.Errorf("key %q was never inserted but is found", absent)
+		}
+	}
+
+	for i := 0; i < selfTestCanaries; i++ {
+		canary := []byte(fmt.Sprintf("quotient-canary-%d", i))
+		if !f.Remove(canary) {
+			return fmt.Errorf("could not remove canary %q", canary)
+		}
+		if exists, _ := f.Exists(canary); exists {
+			return fmt.Errorf("canary %q was removed but is still found", canary)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// forgetfulFilter simulates a broken build whose lookups never find anything.
+type forgetfulFilter struct {
+	*QuotientFilter
+}
+
+func (f forgetfulFilter) Exists(data []byte) (bool, time.Duration) {
+	return false, 0
+}
+
+func TestQuotientFilterSelfTest(t *testing.T) {
+	if err := NewQuotientFilter(10, WithHashAlgorithm(HashFNV)).SelfTest(); err != nil {
+		t.Errorf("Expected a healthy filter to pass the self-test, got %v", err)
+	}
+
+	err := runCanaries(forgetfulFilter{NewQuotientFilter(8)})
+	if err == nil || !strings.Contains(err.Error(), "is not found") {
+		t.Errorf("Expected a filter with broken lookups to fail the self-test, got %v", err)
+	}
+}
@@ -1,13 +1,29 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/valyala/fasthttp"
+	"gopkg.in/yaml.v3"
+	"io"
 	"log"
+	"mime"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	maxDumpSlots    = 4096
+	defaultKeysPage = 100
+	maxKeysPage     = 1000
+)
+
 type V1InsertParams struct {
 	Key string `json:"key"`
 }
@@ -16,15 +32,57 @@ type V1RemoveParams struct {
 	Key string `json:"key"`
 }
 
+type V1FirstPresentParams struct {
+	Keys []string `json:"keys"`
+}
+
+type V1RemovePrefixParams struct {
+	Prefix string `json:"prefix"`
+}
+
+type V1InsertFingerprintsParams struct {
+	Fingerprints []struct {
+		Quotient  uint64 `json:"q"`
+		Remainder uint64 `json:"r"`
+	} `json:"fingerprints"`
+}
+
+type V1ResizeParams struct {
+	LogSize uint `json:"log_size"`
+}
+
 type V1InsertResponse struct {
 	Key    string `json:"key"`
 	Status string `json:"status"`
 }
 
+type V1TestAndInsertResponse struct {
+	Key     string `json:"key"`
+	Claimed bool   `json:"claimed"`
+}
+
+type V1VerifyResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type V1InsertFingerprintsResponse struct {
+	Inserted int `json:"inserted"`
+	Count    int `json:"count"`
+}
+
 type V1ExistsResponse struct {
-	Key     string        `json:"key"`
-	Exists  bool          `json:"exists"`
-	Elapsed time.Duration `json:"elapsed"`
+	Key           string        `json:"key"`
+	Exists        bool          `json:"exists"`
+	Elapsed       time.Duration `json:"elapsed"`
+	ElapsedMicros float64       `json:"elapsed_micros"`
+	Debug         *LookupTrace  `json:"debug,omitempty"`
+}
+
+type V1FirstPresentResponse struct {
+	Key   string `json:"key,omitempty"`
+	Index int    `json:"index"`
+	Found bool   `json:"found"`
 }
 
 type V1RemoveResponse struct {
@@ -32,35 +90,227 @@ type V1RemoveResponse struct {
 	Removed bool   `json:"removed"`
 }
 
+type V1RemovePrefixResponse struct {
+	Prefix  string `json:"prefix"`
+	Removed int    `json:"removed"`
+}
+
 type V1CountResponse struct {
+	Count      int     `json:"count"`
+	Capacity   int     `json:"capacity"`
+	LoadFactor float64 `json:"load_factor"`
+}
+
+type V1StatsResponse struct {
+	Count      int            `json:"count"`
+	Capacity   int            `json:"capacity"`
+	LoadFactor float64        `json:"load_factor"`
+	Checksum   string         `json:"checksum"`
+	RunLengths RunLengthStats `json:"run_lengths"`
+}
+
+type V1ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+type V1KeysResponse struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// V1KeysStreamEntry is one line of a /v1/keys NDJSON stream.
+type V1KeysStreamEntry struct {
+	Key string `json:"key"`
+}
+
+type V1ImportResponse struct {
 	Count int `json:"count"`
 }
 
+type V1DebugStripesResponse struct {
+	Stripes []StripeStat `json:"stripes"`
+}
+
+type V1ResizeResponse struct {
+	LogSize  uint `json:"log_size"`
+	Capacity int  `json:"capacity"`
+	Count    int  `json:"count"`
+}
+
+type V1DebugDumpResponse struct {
+	From  uint64     `json:"from"`
+	To    uint64     `json:"to"`
+	Slots []SlotDump `json:"slots"`
+}
+
 func StartServer(config *Config) {
-	port := fmt.Sprintf(":%d", config.Server.Port)
-	host := config.Server.Host
-	log.Println(fmt.Sprintf("Starting server on at: http://%s%s", host, port))
-
-	requestHandler := func(ctx *fasthttp.RequestCtx) {
-		switch string(ctx.Path()) {
-		case "/":
-			homeHandler(ctx)
-		case "/v1/insert":
-			v1InsertHandler(ctx)
-		case "/v1/exists":
-			v1ExistsHandler(ctx)
-		case "/v1/remove":
-			v1RemoveHandler(ctx)
-		case "/v1/count":
-			v1CountHandler(ctx)
-		default:
-			notFoundHandler(ctx)
-		}
-	}
-
-	if err := fasthttp.ListenAndServe(port, requestHandler); err != nil {
+	var deadLetters *DeadLetterLog
+	if config.Server.DeadLetterPath != "" {
+		var err error
+		deadLetters, err = OpenDeadLetterLog(config.Server.DeadLetterPath)
+		if err != nil {
+			log.Fatalf("Error opening dead-letter log: %s", err)
+		}
+	}
+
+	var audit *AuditLog
+	if config.Server.AuditLogPath != "" {
+		var err error
+		audit, err = OpenAuditLog(config.Server.AuditLogPath, config.Server.AuditSampleRate, config.Server.AuditSalt, config.Raft.NodeID)
+		if err != nil {
+			log.Fatalf("Error opening audit log: %s", err)
+		}
+		WithAuditLog(audit)(QF)
+	}
+
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	defer stopWorker()
+	AsyncInserts = NewAsyncInserter(config.Server.AsyncQueueSize, deadLetters)
+	go AsyncInserts.Run(workerCtx, QF)
+
+	// Request bodies are streamed so /v1/import can take exports larger than
+	// fasthttp's default body limit.
+	server := &fasthttp.Server{
+		Handler:           newRequestHandler(config),
+		StreamRequestBody: true,
+	}
+	go shutdownOnSignal(server)
+	if err := serve(server, config); err != nil {
 		log.Fatalf("Error in ListenAndServe: %s", err)
 	}
+
+	// The worker applies the inserts accepted before the shutdown, and may
+	// record failures in the logs, so they are closed once it returns.
+	AsyncInserts.Close()
+	if deadLetters != nil {
+		if err := deadLetters.Close(); err != nil {
+			log.Printf("Error closing dead-letter log: %s", err)
+		}
+	}
+	if audit != nil {
+		if err := audit.Close(); err != nil {
+			log.Printf("Error closing audit log: %s", err)
+		}
+	}
+}
+
+// serve listens on the TCP port, the unix socket or both, as configured, and
+// returns once the server is shut down or a listener fails.
+func serve(server *fasthttp.Server, config *Config) error {
+	errs := make(chan error, 2)
+	listeners := 0
+
+	if config.Server.UnixSocket != "" {
+		mode, err := parseFileMode(config.Server.UnixSocketMode)
+		if err != nil {
+			return err
+		}
+		log.Printf("Listening on unix socket %s", config.Server.UnixSocket)
+		go func() { errs <- server.ListenAndServeUNIX(config.Server.UnixSocket, mode) }()
+		listeners++
+	}
+	if !config.Server.DisableTCP {
+		port := fmt.Sprintf(":%d", config.Server.Port)
+		log.Println(fmt.Sprintf("Starting server on at: http://%s%s", config.Server.Host, port))
+		go func() { errs <- server.ListenAndServe(port) }()
+		listeners++
+	}
+
+	for ; listeners > 0; listeners-- {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newRequestHandler(config *Config) fasthttp.RequestHandler {
+	handler := withDrain(routeRequest)
+	if config.Server.Compression {
+		handler = withCompression(handler, config.Server.CompressionMinSize)
+	}
+	if config.Server.AccessLog {
+		handler = withAccessLog(handler)
+	}
+	return handler
+}
+
+func routeRequest(ctx *fasthttp.RequestCtx) {
+	switch string(ctx.Path()) {
+	case "/":
+		homeHandler(ctx)
+	case "/v1/insert":
+		v1InsertHandler(ctx)
+	case "/v1/test_and_insert":
+		v1TestAndInsertHandler(ctx)
+	case "/v1/insert_fingerprints":
+		v1InsertFingerprintsHandler(ctx)
+	case "/v1/exists":
+		v1ExistsHandler(ctx)
+	case "/v1/first_present":
+		v1FirstPresentHandler(ctx)
+	case "/v1/remove":
+		v1RemoveHandler(ctx)
+	case "/v1/remove_prefix":
+		v1RemovePrefixHandler(ctx)
+	case "/v1/count":
+		v1CountHandler(ctx)
+	case "/v1/stats":
+		v1StatsHandler(ctx)
+	case "/v1/keys":
+		v1KeysHandler(ctx)
+	case "/v1/resize":
+		v1ResizeHandler(ctx)
+	case "/v1/export":
+		v1ExportHandler(ctx)
+	case "/v1/import":
+		v1ImportHandler(ctx)
+	case "/v1/debug/dump":
+		v1DebugDumpHandler(ctx)
+	case "/v1/debug/stripes":
+		v1DebugStripesHandler(ctx)
+	case "/v1/verify":
+		v1VerifyHandler(ctx)
+	case "/v1/drain":
+		v1DrainHandler(ctx)
+	case "/v1/config":
+		v1ConfigHandler(ctx)
+	case "/metrics":
+		metricsHandler(ctx)
+	default:
+		notFoundHandler(ctx)
+	}
+}
+
+// withAccessLog logs one line per request with its method, path, status and
+// duration. It is only installed when server.access_log is set.
+func withAccessLog(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		startTime := time.Now()
+		next(ctx)
+		log.Printf("%s %s %d %s", ctx.Method(), ctx.Path(), ctx.Response.StatusCode(), time.Since(startTime))
+	}
+}
+
+// withCompression gzips response bodies of at least minSize bytes for
+// clients that accept it. Smaller bodies are not worth the CPU, and streamed
+// bodies such as /v1/export are sent as they are.
+func withCompression(next fasthttp.RequestHandler, minSize int) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+
+		ctx.Response.Header.Add("Vary", "Accept-Encoding")
+		if !ctx.Request.Header.HasAcceptEncoding("gzip") ||
+			ctx.Response.IsBodyStream() ||
+			len(ctx.Response.Body()) < minSize ||
+			len(ctx.Response.Header.ContentEncoding()) > 0 {
+			return
+		}
+
+		ctx.Response.SetBody(fasthttp.AppendGzipBytes(nil, ctx.Response.Body()))
+		ctx.Response.Header.SetContentEncoding("gzip")
+	}
 }
 
 func homeHandler(ctx *fasthttp.RequestCtx) {
@@ -80,6 +330,10 @@ func v1InsertHandler(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	if !checkJSONBody(ctx) {
+		return
+	}
+
 	body := ctx.PostBody()
 	bodyString := []byte(string(body))
 	var jsonBody V1InsertParams
@@ -97,10 +351,37 @@ func v1InsertHandler(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	insertError := QF.Insert([]byte(jsonBody.Key))
+	if ctx.QueryArgs().GetBool("async") {
+		if !AsyncInserts.Enqueue([]byte(jsonBody.Key)) {
+			ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+			ctx.SetBody([]byte("Async insert queue is full"))
+			return
+		}
+
+		response := V1InsertResponse{Key: jsonBody.Key, Status: "queued"}
+		responseJSON, err := json.Marshal(response)
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBody([]byte(err.Error()))
+			return
+		}
+
+		ctx.SetStatusCode(fasthttp.StatusAccepted)
+		ctx.SetContentType("application/json")
+		ctx.SetBody(responseJSON)
+		return
+	}
+
+	added, insertError := QF.InsertNew([]byte(jsonBody.Key))
+	recordInsert(added, insertError)
+	if errors.Is(insertError, ErrFilterFull) {
+		errorResponse(ctx, fasthttp.StatusInsufficientStorage, "FILTER_FULL", insertError)
+		return
+	}
 	if insertError != nil {
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		ctx.SetBody([]byte(insertError.Error()))
+		return
 	}
 
 	response := V1InsertResponse{Key: jsonBody.Key, Status: "inserted"}
@@ -116,7 +397,117 @@ func v1InsertHandler(ctx *fasthttp.RequestCtx) {
 	ctx.SetBody(responseJSON)
 }
 
+// v1TestAndInsertHandler inserts a key and reports whether this request was
+// the one that added it, so that callers can claim an ID exactly once. A
+// false positive of the filter reads as an already claimed key.
+func v1TestAndInsertHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	if !checkJSONBody(ctx) {
+		return
+	}
+
+	var jsonBody V1InsertParams
+	if err := json.Unmarshal(ctx.PostBody(), &jsonBody); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	if jsonBody.Key == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte("Key is required"))
+		return
+	}
+
+	added, insertError := QF.InsertNew([]byte(jsonBody.Key))
+	recordInsert(added, insertError)
+	if errors.Is(insertError, ErrFilterFull) {
+		errorResponse(ctx, fasthttp.StatusInsufficientStorage, "FILTER_FULL", insertError)
+		return
+	}
+	if insertError != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(insertError.Error()))
+		return
+	}
+
+	response := V1TestAndInsertResponse{Key: jsonBody.Key, Claimed: added}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+func v1InsertFingerprintsHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	if !checkJSONBody(ctx) {
+		return
+	}
+
+	var jsonBody V1InsertFingerprintsParams
+	if err := json.Unmarshal(ctx.PostBody(), &jsonBody); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	if len(jsonBody.Fingerprints) == 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte("Fingerprints are required"))
+		return
+	}
+
+	fingerprints := make([]Fingerprint, len(jsonBody.Fingerprints))
+	for i, fp := range jsonBody.Fingerprints {
+		fingerprints[i] = Fingerprint{Quotient: fp.Quotient, Remainder: fp.Remainder}
+	}
+
+	err := QF.LoadFingerprints(fingerprints)
+	if errors.Is(err, ErrFilterFull) {
+		errorResponse(ctx, fasthttp.StatusInsufficientStorage, "FILTER_FULL", err)
+		return
+	}
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	response := V1InsertFingerprintsResponse{Inserted: len(fingerprints), Count: QF.Count()}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
 func v1ExistsHandler(ctx *fasthttp.RequestCtx) {
+	if ctx.IsHead() {
+		v1ExistsHeadHandler(ctx)
+		return
+	}
+
 	if !ctx.IsGet() {
 		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
 		ctx.SetBody([]byte("Method not allowed"))
@@ -131,7 +522,18 @@ func v1ExistsHandler(ctx *fasthttp.RequestCtx) {
 	}
 
 	exists, elapsed := QF.Exists([]byte(key))
-	response := V1ExistsResponse{Key: key, Exists: exists, Elapsed: elapsed}
+	recordExists(exists, elapsed)
+	response := V1ExistsResponse{
+		Key:           key,
+		Exists:        exists,
+		Elapsed:       elapsed,
+		ElapsedMicros: float64(elapsed) / float64(time.Microsecond),
+	}
+
+	if ctx.QueryArgs().GetBool("debug") {
+		_, trace := QF.Trace([]byte(key))
+		response.Debug = &trace
+	}
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
@@ -144,32 +546,111 @@ func v1ExistsHandler(ctx *fasthttp.RequestCtx) {
 	ctx.SetBody(responseJSON)
 }
 
-func v1RemoveHandler(ctx *fasthttp.RequestCtx) {
+// v1FirstPresentHandler looks up a list of key variants in priority order and
+// answers with the first one that may exist.
+func v1FirstPresentHandler(ctx *fasthttp.RequestCtx) {
 	if !ctx.IsPost() {
 		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
 		ctx.SetBody([]byte("Method not allowed"))
 		return
 	}
 
-	body := ctx.PostBody()
-	bodyString := []byte(string(body))
-	var jsonBody V1RemoveParams
+	if !checkJSONBody(ctx) {
+		return
+	}
 
-	err := json.Unmarshal(bodyString, &jsonBody)
-	if err != nil {
+	var jsonBody V1FirstPresentParams
+	if err := json.Unmarshal(ctx.PostBody(), &jsonBody); err != nil {
 		ctx.SetStatusCode(fasthttp.StatusBadRequest)
 		ctx.SetBody([]byte(err.Error()))
 		return
 	}
 
-	if jsonBody.Key == "" {
+	if len(jsonBody.Keys) == 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte("Keys are required"))
+		return
+	}
+
+	keys := make([][]byte, len(jsonBody.Keys))
+	for i, key := range jsonBody.Keys {
+		if key == "" {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBody([]byte(fmt.Sprintf("Key %d is empty", i)))
+			return
+		}
+		keys[i] = []byte(key)
+	}
+
+	response := V1FirstPresentResponse{}
+	response.Index, response.Found = QF.FirstPresent(keys)
+	if response.Found {
+		response.Key = jsonBody.Keys[response.Index]
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+// v1ExistsHeadHandler answers HEAD /v1/exists with the status code alone:
+// 200 when the key may exist and 404 when it does not.
+func v1ExistsHeadHandler(ctx *fasthttp.RequestCtx) {
+	key := ctx.QueryArgs().Peek("key")
+	if len(key) == 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		return
+	}
+
+	exists, elapsed := QF.Exists(key)
+	recordExists(exists, elapsed)
+
+	if exists {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	} else {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+	}
+}
+
+// v1RemoveHandler removes the key given either in a POST JSON body or, for
+// symmetry with /v1/exists, in the key query parameter of a DELETE.
+func v1RemoveHandler(ctx *fasthttp.RequestCtx) {
+	var key string
+	switch {
+	case ctx.IsDelete():
+		key = string(ctx.QueryArgs().Peek("key"))
+	case ctx.IsPost():
+		if !checkJSONBody(ctx) {
+			return
+		}
+
+		var jsonBody V1RemoveParams
+		if err := json.Unmarshal(ctx.PostBody(), &jsonBody); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBody([]byte(err.Error()))
+			return
+		}
+		key = jsonBody.Key
+	default:
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	if key == "" {
 		ctx.SetStatusCode(fasthttp.StatusBadRequest)
 		ctx.SetBody([]byte("Key is required"))
 		return
 	}
 
-	removed := QF.Remove([]byte(jsonBody.Key))
-	response := V1RemoveResponse{Key: jsonBody.Key, Removed: removed}
+	removed := QF.Remove([]byte(key))
+	response := V1RemoveResponse{Key: key, Removed: removed}
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
@@ -180,7 +661,49 @@ func v1RemoveHandler(ctx *fasthttp.RequestCtx) {
 	ctx.SetStatusCode(fasthttp.StatusOK)
 	ctx.SetContentType("application/json")
 	ctx.SetBody(responseJSON)
+}
+
+func v1RemovePrefixHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	if !QF.RetainsKeys() {
+		ctx.SetStatusCode(fasthttp.StatusNotImplemented)
+		ctx.SetBody([]byte("Removing keys by prefix requires quotient.retainKeys"))
+		return
+	}
+
+	if !checkJSONBody(ctx) {
+		return
+	}
+
+	var jsonBody V1RemovePrefixParams
+	if err := json.Unmarshal(ctx.PostBody(), &jsonBody); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
 
+	if jsonBody.Prefix == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte("Prefix is required"))
+		return
+	}
+
+	response := V1RemovePrefixResponse{Prefix: jsonBody.Prefix, Removed: QF.RemoveByPrefix([]byte(jsonBody.Prefix))}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
 }
 
 func v1CountHandler(ctx *fasthttp.RequestCtx) {
@@ -190,8 +713,12 @@ func v1CountHandler(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	count := QF.Count()
-	response := V1CountResponse{Count: count}
+	count, capacity := QF.Count(), QF.Capacity()
+	response := V1CountResponse{
+		Count:      count,
+		Capacity:   capacity,
+		LoadFactor: float64(count) / float64(capacity),
+	}
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
@@ -203,3 +730,447 @@ func v1CountHandler(ctx *fasthttp.RequestCtx) {
 	ctx.SetContentType("application/json")
 	ctx.SetBody(responseJSON)
 }
+
+// v1ConfigHandler returns the configuration in effect, after defaults were
+// merged in, with secrets redacted. It goes through YAML first so that the
+// keys and duration formats are those of the config file.
+func v1ConfigHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	configYAML, err := yaml.Marshal(Configuration.Redacted())
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+	var effective map[string]interface{}
+	if err := yaml.Unmarshal(configYAML, &effective); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	responseJSON, err := json.Marshal(effective)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+// v1VerifyHandler runs the invariant checker on demand. A broken invariant
+// answers 500 Internal Server Error, so the endpoint can back a health check.
+func v1VerifyHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	status := fasthttp.StatusOK
+	response := V1VerifyResponse{OK: true}
+	if err := QF.Verify(); err != nil {
+		status = fasthttp.StatusInternalServerError
+		response = V1VerifyResponse{Error: err.Error()}
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+func v1StatsHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	response := V1StatsResponse{
+		Count:      QF.Count(),
+		Capacity:   QF.Capacity(),
+		LoadFactor: QF.LoadFactor(),
+		Checksum:   fmt.Sprintf("%016x", QF.Checksum()),
+		RunLengths: QF.RunLengthStats(),
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+func v1KeysHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	if !QF.RetainsKeys() {
+		ctx.SetStatusCode(fasthttp.StatusNotImplemented)
+		ctx.SetBody([]byte("Listing keys requires quotient.retainKeys"))
+		return
+	}
+
+	// The cursor is the last key of the previous page, encoded so it can be
+	// passed back as-is in a query string.
+	after, err := base64.RawURLEncoding.DecodeString(string(ctx.QueryArgs().Peek("cursor")))
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte("Invalid cursor"))
+		return
+	}
+
+	if acceptsNDJSON(ctx) {
+		streamKeys(ctx, QF, after)
+		return
+	}
+
+	limit, err := parseUintQueryArg(ctx, "limit", defaultKeysPage)
+	if err != nil || limit == 0 || limit > maxKeysPage {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(fmt.Sprintf("limit must be between 1 and %d", maxKeysPage)))
+		return
+	}
+
+	keys, more := QF.KeysAfter(after, int(limit))
+	response := V1KeysResponse{Keys: make([]string, len(keys))}
+	for i, key := range keys {
+		response.Keys[i] = string(key)
+	}
+	if more {
+		response.NextCursor = base64.RawURLEncoding.EncodeToString(keys[len(keys)-1])
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+// streamKeys answers with every key of qf after the key after as
+// newline-delimited JSON, one V1KeysStreamEntry per line. Keys are read a
+// page at a time and each page is flushed before the next is read, so
+// neither side holds the whole listing. Like paging, the stream is only a
+// consistent listing while the filter is unchanged.
+func streamKeys(ctx *fasthttp.RequestCtx, qf *QuotientFilter, after []byte) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/x-ndjson")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+		for {
+			keys, more := qf.KeysAfter(after, maxKeysPage)
+			for _, key := range keys {
+				if err := encoder.Encode(V1KeysStreamEntry{Key: string(key)}); err != nil {
+					log.Printf("Error streaming keys: %s", err)
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+			if !more {
+				return
+			}
+			after = keys[len(keys)-1]
+		}
+	})
+}
+
+func v1ResizeHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	if !checkJSONBody(ctx) {
+		return
+	}
+
+	var jsonBody V1ResizeParams
+	if err := json.Unmarshal(ctx.PostBody(), &jsonBody); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	if jsonBody.LogSize == 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte("log_size is required"))
+		return
+	}
+
+	if err := checkFilterMemory(jsonBody.LogSize, Configuration.Quotient.MaxMemoryFraction); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	if err := QF.Resize(jsonBody.LogSize); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	response := V1ResizeResponse{LogSize: jsonBody.LogSize, Capacity: QF.Capacity(), Count: QF.Count()}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+func v1ExportHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/octet-stream")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		if _, err := QF.WriteTo(w); err != nil {
+			log.Printf("Error exporting filter: %s", err)
+		}
+	})
+}
+
+func v1ImportHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	body := ctx.RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(ctx.PostBody())
+	}
+
+	_, err := QF.ReadFrom(body)
+	if errors.Is(err, ErrFilterMismatch) {
+		ImportMismatches.Inc()
+		errorResponse(ctx, fasthttp.StatusConflict, "FILTER_MISMATCH", err)
+		return
+	}
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(fmt.Sprintf("Could not import filter: %s", err)))
+		return
+	}
+
+	response := V1ImportResponse{Count: QF.Count()}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+func metricsHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("text/plain; version=0.0.4")
+	WriteMetrics(ctx)
+}
+
+func v1DebugDumpHandler(ctx *fasthttp.RequestCtx) {
+	if !Configuration.Server.Debug {
+		notFoundHandler(ctx)
+		return
+	}
+
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	from, err := parseUintQueryArg(ctx, "from", 0)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	to, err := parseUintQueryArg(ctx, "to", from+maxDumpSlots)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	if to < from || to-from > maxDumpSlots {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBody([]byte(fmt.Sprintf("Range must be ascending and span at most %d slots", maxDumpSlots)))
+		return
+	}
+
+	slots := QF.Dump(from, to)
+	response := V1DebugDumpResponse{From: from, To: from + uint64(len(slots)), Slots: slots}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+// errorResponse answers with a JSON body carrying a machine-readable code, for
+// errors clients are expected to tell apart.
+func errorResponse(ctx *fasthttp.RequestCtx, status int, code string, err error) {
+	responseJSON, marshalErr := json.Marshal(V1ErrorResponse{Error: err.Error(), Code: code})
+	if marshalErr != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(marshalErr.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+// acceptsNDJSON reports whether the client asked for newline-delimited JSON
+// in its Accept header.
+func acceptsNDJSON(ctx *fasthttp.RequestCtx) bool {
+	for _, accepted := range strings.Split(string(ctx.Request.Header.Peek("Accept")), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted)); err == nil && mediaType == "application/x-ndjson" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkJSONBody rejects a request body that is not declared as JSON or is
+// larger than server.max_body_size, before any time is spent decoding it.
+func checkJSONBody(ctx *fasthttp.RequestCtx) bool {
+	mediaType, _, err := mime.ParseMediaType(string(ctx.Request.Header.ContentType()))
+	if err != nil || mediaType != "application/json" {
+		ctx.SetStatusCode(fasthttp.StatusUnsupportedMediaType)
+		ctx.SetBody([]byte("Content-Type must be application/json"))
+		return false
+	}
+
+	maxBodySize := Configuration.Server.MaxBodySize
+	if ctx.Request.Header.ContentLength() > maxBodySize {
+		bodyTooLarge(ctx)
+		return false
+	}
+
+	// Request bodies are streamed, so a chunked body of unknown length is read
+	// up to one byte past the limit at most, instead of whole by PostBody.
+	if stream := ctx.RequestBodyStream(); stream != nil {
+		body, err := io.ReadAll(io.LimitReader(stream, int64(maxBodySize)+1))
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBody([]byte(fmt.Sprintf("Could not read request body: %s", err)))
+			return false
+		}
+		ctx.Request.SetBody(body)
+	}
+	if len(ctx.PostBody()) > maxBodySize {
+		bodyTooLarge(ctx)
+		return false
+	}
+
+	return true
+}
+
+func bodyTooLarge(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
+	ctx.SetBody([]byte(fmt.Sprintf("Request body exceeds %d bytes", Configuration.Server.MaxBodySize)))
+}
+
+func v1DebugStripesHandler(ctx *fasthttp.RequestCtx) {
+	if !Configuration.Server.Debug {
+		notFoundHandler(ctx)
+		return
+	}
+
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.SetBody([]byte("Method not allowed"))
+		return
+	}
+
+	stats := QF.StripeStats()
+	if stats == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotImplemented)
+		ctx.SetBody([]byte("Stripe stats require quotient.stripeStats"))
+		return
+	}
+
+	response := V1DebugStripesResponse{Stripes: stats}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(err.Error()))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(responseJSON)
+}
+
+func parseUintQueryArg(ctx *fasthttp.RequestCtx, name string, defaultValue uint64) (uint64, error) {
+	raw := ctx.QueryArgs().Peek(name)
+	if len(raw) == 0 {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s: %s", name, raw)
+	}
+	return value, nil
+}
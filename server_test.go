@@ -0,0 +1,828 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spaolacci/murmur3"
+	"github.com/valyala/fasthttp"
+)
+
+// useTestFilter swaps the global filter and configuration for the duration
+// of a test.
+func useTestFilter(t *testing.T, logSize uint) *QuotientFilter {
+	t.Helper()
+
+	previousQF, previousConfig := QF, Configuration
+	config := *createDefaultConfig()
+	config.Quotient.LogSize = logSize
+
+	QF = NewQuotientFilter(logSize)
+	Configuration = &config
+	t.Cleanup(func() {
+		QF, Configuration = previousQF, previousConfig
+	})
+
+	return QF
+}
+
+func newTestRequestCtx(method, uri string, body []byte) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if body != nil {
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.SetBody(body)
+	}
+	return ctx
+}
+
+func TestV1DebugDumpHandler(t *testing.T) {
+	qf := useTestFilter(t, 8)
+
+	keys := []string{"alpha", "beta", "gamma"}
+	expectedOccupied := make(map[uint64]bool)
+	for _, key := range keys {
+		if err := qf.Insert([]byte(key)); err != nil {
+			t.Fatalf("Failed to insert %s: %v", key, err)
+		}
+		quotient, _ := qf.hash([]byte(key))
+		expectedOccupied[quotient] = true
+	}
+
+	t.Run("Disabled without debug flag", func(t *testing.T) {
+		ctx := newTestRequestCtx("GET", "/v1/debug/dump", nil)
+		v1DebugDumpHandler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fasthttp.StatusNotFound, ctx.Response.StatusCode())
+		}
+	})
+
+	Configuration.Server.Debug = true
+
+	t.Run("Dump reflects occupied quotients", func(t *testing.T) {
+		ctx := newTestRequestCtx("GET", fmt.Sprintf("/v1/debug/dump?from=0&to=%d", 1<<8), nil)
+		v1DebugDumpHandler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+
+		var response V1DebugDumpResponse
+		if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response.Slots) != 1<<8 {
+			t.Fatalf("Expected %d slots, got %d", 1<<8, len(response.Slots))
+		}
+
+		for quotient := range expectedOccupied {
+			if !response.Slots[quotient].Occupied {
+				t.Errorf("Slot %d should be marked occupied", quotient)
+			}
+		}
+	})
+
+	t.Run("Rejects oversized ranges", func(t *testing.T) {
+		ctx := newTestRequestCtx("GET", fmt.Sprintf("/v1/debug/dump?from=0&to=%d", maxDumpSlots+1), nil)
+		v1DebugDumpHandler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+		}
+	})
+}
+
+func TestV1StatsHandler(t *testing.T) {
+	qf := useTestFilter(t, 8)
+	for _, key := range []string{"alpha", "beta"} {
+		qf.Insert([]byte(key))
+	}
+
+	ctx := newTestRequestCtx("GET", "/v1/stats", nil)
+	v1StatsHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fasthttp.StatusOK, ctx.Response.StatusCode())
+	}
+
+	var response V1StatsResponse
+	if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.Count != 2 || response.Capacity != 1<<8 {
+		t.Errorf("Expected count 2 and capacity %d, got %+v", 1<<8, response)
+	}
+	if expected := fmt.Sprintf("%016x", qf.Checksum()); response.Checksum != expected {
+		t.Errorf("Expected checksum %s, got %s", expected, response.Checksum)
+	}
+	if response.RunLengths != qf.RunLengthStats() {
+		t.Errorf("Expected run lengths %+v, got %+v", qf.RunLengthStats(), response.RunLengths)
+	}
+}
+
+func TestV1InsertHandlerAsync(t *testing.T) {
+	qf := useTestFilter(t, 8)
+
+	previousInserts := AsyncInserts
+	AsyncInserts = NewAsyncInserter(1, nil)
+	t.Cleanup(func() { AsyncInserts = previousInserts })
+
+	ctx := newTestRequestCtx("POST", "/v1/insert?async=true", []byte(`{"key":"queued"}`))
+	v1InsertHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusAccepted, ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	t.Run("Full queue applies backpressure", func(t *testing.T) {
+		ctx := newTestRequestCtx("POST", "/v1/insert?async=true", []byte(`{"key":"rejected"}`))
+		v1InsertHandler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusTooManyRequests {
+			t.Errorf("Expected status %d, got %d", fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+		}
+	})
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go AsyncInserts.Run(runCtx, qf)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if exists, _ := qf.Exists([]byte("queued")); exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Async insert never became visible")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestV1ExistsHandlerDebug(t *testing.T) {
+	qf := useTestFilter(t, 8)
+	qf.Insert([]byte("present"))
+
+	ctx := newTestRequestCtx("GET", "/v1/exists?key=present", nil)
+	v1ExistsHandler(ctx)
+
+	var plain map[string]interface{}
+	if err := json.Unmarshal(ctx.Response.Body(), &plain); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if _, ok := plain["debug"]; ok {
+		t.Errorf("Expected no debug fields by default, got %s", ctx.Response.Body())
+	}
+
+	ctx = newTestRequestCtx("GET", "/v1/exists?key=present&debug=true", nil)
+	v1ExistsHandler(ctx)
+
+	var response V1ExistsResponse
+	if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Debug == nil {
+		t.Fatalf("Expected debug fields with debug=true, got %s", ctx.Response.Body())
+	}
+
+	quotient, remainder := qf.hash([]byte("present"))
+	if response.Debug.Quotient != quotient || response.Debug.Remainder != remainder {
+		t.Errorf("Expected quotient %d and remainder %d, got %+v", quotient, remainder, response.Debug)
+	}
+	if !response.Debug.Occupied || response.Debug.RunLength != 1 || response.Debug.Walked != 1 {
+		t.Errorf("Expected a single-entry run walked once, got %+v", response.Debug)
+	}
+	if response.Debug.MatchedSlot == nil || *response.Debug.MatchedSlot != quotient {
+		t.Errorf("Expected a match in slot %d, got %+v", quotient, response.Debug)
+	}
+}
+
+func TestJSONBodyChecks(t *testing.T) {
+	qf := useTestFilter(t, 8)
+	Configuration.Server.MaxBodySize = 64
+
+	handlers := map[string]fasthttp.RequestHandler{
+		"/v1/insert": v1InsertHandler,
+		"/v1/remove": v1RemoveHandler,
+	}
+
+	for path, handler := range handlers {
+		t.Run(path, func(t *testing.T) {
+			t.Run("Wrong content type", func(t *testing.T) {
+				ctx := newTestRequestCtx("POST", path, []byte(`{"key":"plain"}`))
+				ctx.Request.Header.SetContentType("text/plain")
+				handler(ctx)
+				if ctx.Response.StatusCode() != fasthttp.StatusUnsupportedMediaType {
+					t.Errorf("Expected status %d, got %d", fasthttp.StatusUnsupportedMediaType, ctx.Response.StatusCode())
+				}
+			})
+
+			t.Run("Oversized body", func(t *testing.T) {
+				body := []byte(fmt.Sprintf(`{"key":"%s"}`, strings.Repeat("x", 64)))
+				ctx := newTestRequestCtx("POST", path, body)
+				handler(ctx)
+				if ctx.Response.StatusCode() != fasthttp.StatusRequestEntityTooLarge {
+					t.Errorf("Expected status %d, got %d", fasthttp.StatusRequestEntityTooLarge, ctx.Response.StatusCode())
+				}
+			})
+
+			t.Run("Oversized chunked body", func(t *testing.T) {
+				ctx := newTestRequestCtx("POST", path, []byte{})
+				body := &endlessReader{}
+				ctx.Request.SetBodyStream(body, -1)
+				handler(ctx)
+				if ctx.Response.StatusCode() != fasthttp.StatusRequestEntityTooLarge {
+					t.Errorf("Expected status %d, got %d", fasthttp.StatusRequestEntityTooLarge, ctx.Response.StatusCode())
+				}
+				if body.read > 65 {
+					t.Errorf("Expected at most 65 bytes to be read, read %d", body.read)
+				}
+			})
+
+			t.Run("Chunked body within the limit", func(t *testing.T) {
+				ctx := newTestRequestCtx("POST", path, []byte{})
+				ctx.Request.SetBodyStream(strings.NewReader(`{"key":"chunked"}`), -1)
+				handler(ctx)
+				if ctx.Response.StatusCode() != fasthttp.StatusOK {
+					t.Errorf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+				}
+				qf.Remove([]byte("chunked"))
+			})
+
+			t.Run("Empty key", func(t *testing.T) {
+				ctx := newTestRequestCtx("POST", path, []byte(`{"key":""}`))
+				ctx.Request.Header.SetContentType("application/json; charset=utf-8")
+				handler(ctx)
+				if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+					t.Errorf("Expected status %d, got %d", fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+				}
+			})
+		})
+	}
+
+	if qf.Count() != 0 {
+		t.Errorf("Expected rejected requests to leave the filter empty, but found %d items", qf.Count())
+	}
+}
+
+// endlessReader is a request body that never ends, counting the bytes read.
+type endlessReader struct {
+	read int
+}
+
+func (er *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	er.read += len(p)
+	return len(p), nil
+}
+
+func TestV1InsertHandlerFilterFull(t *testing.T) {
+	qf := useTestFilter(t, 4)
+
+	for i := 0; qf.Count() < qf.Capacity(); i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+	if err := qf.Insert([]byte("overflow")); !errors.Is(err, ErrFilterFull) {
+		t.Fatalf("Expected ErrFilterFull from a full filter, got %v", err)
+	}
+
+	ctx := newTestRequestCtx("POST", "/v1/insert", []byte(`{"key":"overflow"}`))
+	v1InsertHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusInsufficientStorage {
+		t.Fatalf("Expected status %d, got %d", fasthttp.StatusInsufficientStorage, ctx.Response.StatusCode())
+	}
+
+	var response V1ErrorResponse
+	if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Code != "FILTER_FULL" {
+		t.Errorf("Expected error code FILTER_FULL, got %q", response.Code)
+	}
+}
+
+func TestV1KeysHandler(t *testing.T) {
+	useTestFilter(t, 8)
+
+	t.Run("Not implemented without retained keys", func(t *testing.T) {
+		ctx := newTestRequestCtx("GET", "/v1/keys", nil)
+		v1KeysHandler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusNotImplemented {
+			t.Errorf("Expected status %d, got %d", fasthttp.StatusNotImplemented, ctx.Response.StatusCode())
+		}
+	})
+
+	QF = NewKeyRetainingQuotientFilter(8)
+	expected := make(map[string]bool)
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key%d", i)
+		QF.Insert([]byte(key))
+		expected[key] = true
+	}
+
+	visited := make(map[string]int)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(expected) {
+			t.Fatal("Paging did not terminate")
+		}
+
+		ctx := newTestRequestCtx("GET", "/v1/keys?limit=7&cursor="+cursor, nil)
+		v1KeysHandler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+
+		var response V1KeysResponse
+		if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response.Keys) > 7 {
+			t.Fatalf("Expected at most 7 keys per page, got %d", len(response.Keys))
+		}
+		for _, key := range response.Keys {
+			visited[key]++
+		}
+
+		if response.NextCursor == "" {
+			break
+		}
+		cursor = response.NextCursor
+	}
+
+	for key := range expected {
+		if visited[key] != 1 {
+			t.Errorf("Expected %s to be listed once, but it was listed %d times", key, visited[key])
+		}
+	}
+	if len(visited) != len(expected) {
+		t.Errorf("Expected %d keys, got %d", len(expected), len(visited))
+	}
+}
+
+func TestV1KeysHandlerNDJSON(t *testing.T) {
+	useTestFilter(t, 12)
+
+	// More keys than fit a page, so the stream spans several of them.
+	QF = NewKeyRetainingQuotientFilter(12)
+	const keys = 2*maxKeysPage + 10
+	for i := 0; i < keys; i++ {
+		QF.Insert([]byte(fmt.Sprintf("key%d", i)))
+	}
+
+	ctx := newTestRequestCtx("GET", "/v1/keys", nil)
+	ctx.Request.Header.Set("Accept", "application/x-ndjson")
+	v1KeysHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if contentType := string(ctx.Response.Header.ContentType()); contentType != "application/x-ndjson" {
+		t.Errorf("Expected an NDJSON content type, got %q", contentType)
+	}
+
+	visited := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(ctx.Response.Body()))
+	for scanner.Scan() {
+		var entry V1KeysStreamEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to decode line %q: %v", scanner.Text(), err)
+		}
+		if visited[entry.Key] {
+			t.Errorf("Expected %s to be streamed once", entry.Key)
+		}
+		visited[entry.Key] = true
+	}
+	if len(visited) != keys {
+		t.Errorf("Expected %d streamed keys, got %d", keys, len(visited))
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	useTestFilter(t, 8)
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, enabled := range []bool{false, true} {
+		output.Reset()
+		Configuration.Server.AccessLog = enabled
+		handler := newRequestHandler(Configuration)
+		for i := 0; i < 3; i++ {
+			handler(newTestRequestCtx("GET", "/v1/count", nil))
+		}
+
+		lines := strings.Count(output.String(), "\n")
+		if !enabled && lines != 0 {
+			t.Errorf("Expected no log output with access_log disabled, got %q", output.String())
+		}
+		if enabled && lines != 3 {
+			t.Errorf("Expected 3 access log lines, got %q", output.String())
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	useTestFilter(t, 10)
+	QF = NewKeyRetainingQuotientFilter(10)
+	for i := 0; i < 500; i++ {
+		QF.Insert([]byte(fmt.Sprintf("b4912a59-b0ed-4f68-9042-%012d", i)))
+	}
+	Configuration.Server.Compression = true
+	handler := newRequestHandler(Configuration)
+
+	request := func(uri string, gzip bool) *fasthttp.RequestCtx {
+		ctx := newTestRequestCtx("GET", uri, nil)
+		if gzip {
+			ctx.Request.Header.Set("Accept-Encoding", "gzip")
+		}
+		handler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fasthttp.StatusOK, ctx.Response.StatusCode())
+		}
+		return ctx
+	}
+
+	plain := request("/v1/keys?limit=500", false)
+	if encoding := plain.Response.Header.ContentEncoding(); len(encoding) > 0 {
+		t.Errorf("Expected no content encoding without Accept-Encoding, got %q", encoding)
+	}
+
+	compressed := request("/v1/keys?limit=500", true)
+	if encoding := string(compressed.Response.Header.ContentEncoding()); encoding != "gzip" {
+		t.Fatalf("Expected gzip content encoding, got %q", encoding)
+	}
+	body, err := fasthttp.AppendGunzipBytes(nil, compressed.Response.Body())
+	if err != nil {
+		t.Fatalf("Failed to gunzip response: %v", err)
+	}
+	if !bytes.Equal(body, plain.Response.Body()) {
+		t.Error("Expected the gunzipped body to match the plain one")
+	}
+	if len(compressed.Response.Body()) >= len(body) {
+		t.Errorf("Expected compression to shrink the body, got %d bytes from %d", len(compressed.Response.Body()), len(body))
+	}
+
+	if encoding := request("/v1/count", true).Response.Header.ContentEncoding(); len(encoding) > 0 {
+		t.Errorf("Expected small responses to stay uncompressed, got %q", encoding)
+	}
+}
+
+func TestV1ExportImportHandlers(t *testing.T) {
+	source := useTestFilter(t, 8)
+	for _, key := range []string{"alpha", "beta", "gamma"} {
+		source.Insert([]byte(key))
+	}
+
+	exportCtx := newTestRequestCtx("GET", "/v1/export", nil)
+	v1ExportHandler(exportCtx)
+	if exportCtx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fasthttp.StatusOK, exportCtx.Response.StatusCode())
+	}
+	export := exportCtx.Response.Body()
+
+	QF = NewQuotientFilter(8)
+	importCtx := newTestRequestCtx("POST", "/v1/import", export)
+	v1ImportHandler(importCtx)
+	if importCtx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, importCtx.Response.StatusCode(), importCtx.Response.Body())
+	}
+
+	for _, key := range []string{"alpha", "beta", "gamma"} {
+		if exists, _ := QF.Exists([]byte(key)); !exists {
+			t.Errorf("%s should exist after import, but doesn't", key)
+		}
+	}
+	if QF.Count() != 3 {
+		t.Errorf("Expected 3 items after import, but found %d", QF.Count())
+	}
+
+	mismatches := map[string]*QuotientFilter{
+		"geometry":  NewQuotientFilter(9),
+		"hash seed": NewQuotientFilter(8, WithHashSeed(1)),
+	}
+	for reason, qf := range mismatches {
+		QF = qf
+		rejected := ImportMismatches.Value()
+		importCtx = newTestRequestCtx("POST", "/v1/import", export)
+		v1ImportHandler(importCtx)
+		if importCtx.Response.StatusCode() != fasthttp.StatusConflict {
+			t.Errorf("Expected status %d for a %s mismatch, got %d", fasthttp.StatusConflict, reason, importCtx.Response.StatusCode())
+		}
+
+		var response V1ErrorResponse
+		if err := json.Unmarshal(importCtx.Response.Body(), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.Code != "FILTER_MISMATCH" {
+			t.Errorf("Expected error code FILTER_MISMATCH for a %s mismatch, got %q", reason, response.Code)
+		}
+		if got := ImportMismatches.Value() - rejected; got != 1 {
+			t.Errorf("Expected the %s mismatch to be counted once, got %d", reason, got)
+		}
+		if QF.Count() != 0 {
+			t.Errorf("Expected a rejected import to leave the filter empty, found %d items", QF.Count())
+		}
+	}
+}
+
+func TestV1ExistsHandlerHead(t *testing.T) {
+	qf := useTestFilter(t, 8)
+	qf.Insert([]byte("present"))
+
+	cases := map[string]int{
+		"present": fasthttp.StatusOK,
+		"absent":  fasthttp.StatusNotFound,
+		"":        fasthttp.StatusBadRequest,
+	}
+	for key, status := range cases {
+		ctx := newTestRequestCtx("HEAD", "/v1/exists?key="+key, nil)
+		v1ExistsHandler(ctx)
+		if ctx.Response.StatusCode() != status {
+			t.Errorf("Expected status %d for %q, got %d", status, key, ctx.Response.StatusCode())
+		}
+		if len(ctx.Response.Body()) != 0 {
+			t.Errorf("Expected no body for %q, got %q", key, ctx.Response.Body())
+		}
+	}
+}
+
+func TestV1ExistsHandlerElapsed(t *testing.T) {
+	useTestFilter(t, 8)
+
+	ctx := newTestRequestCtx("GET", "/v1/exists?key=anything", nil)
+	v1ExistsHandler(ctx)
+
+	var plain map[string]interface{}
+	if err := json.Unmarshal(ctx.Response.Body(), &plain); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	elapsed, ok := plain["elapsed"].(float64)
+	if !ok {
+		t.Fatalf("Expected a numeric elapsed field, got %s", ctx.Response.Body())
+	}
+	elapsedMicros, ok := plain["elapsed_micros"].(float64)
+	if !ok {
+		t.Fatalf("Expected a numeric elapsed_micros field, got %s", ctx.Response.Body())
+	}
+	if elapsedMicros != elapsed/1000 {
+		t.Errorf("Expected elapsed_micros to be %v, got %v", elapsed/1000, elapsedMicros)
+	}
+}
+
+func TestV1InsertFingerprintsHandler(t *testing.T) {
+	const logSize = 8
+	qf := useTestFilter(t, logSize)
+
+	hash := murmur3.Sum64WithSeed([]byte("precomputed"), qf.HashSeed())
+	body := fmt.Sprintf(`{"fingerprints":[{"q":%d,"r":%d}]}`, hash&(1<<logSize-1), hash>>logSize)
+
+	ctx := newTestRequestCtx("POST", "/v1/insert_fingerprints", []byte(body))
+	v1InsertFingerprintsHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if exists, _ := qf.Exists([]byte("precomputed")); !exists {
+		t.Error("The key of an inserted fingerprint should exist")
+	}
+
+	ctx = newTestRequestCtx("POST", "/v1/insert_fingerprints", []byte(`{"fingerprints":[{"q":256,"r":1}]}`))
+	v1InsertFingerprintsHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Errorf("Expected status %d for a quotient outside the filter, got %d", fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	}
+}
+
+func TestV1ConfigHandler(t *testing.T) {
+	useTestFilter(t, 8)
+	Configuration.Server.Port = 9123
+	Configuration.Server.APIKey = "s3cr3t"
+	Configuration.Quotient.VerifyInterval = time.Minute
+
+	ctx := newTestRequestCtx("GET", "/v1/config", nil)
+	v1ConfigHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if strings.Contains(string(ctx.Response.Body()), "s3cr3t") {
+		t.Fatalf("Expected the API key to be redacted, got %s", ctx.Response.Body())
+	}
+
+	var response struct {
+		Quotient struct {
+			LogSize        uint   `json:"logSize"`
+			VerifyInterval string `json:"verifyInterval"`
+		} `json:"quotient"`
+		Server struct {
+			Port   int    `json:"port"`
+			APIKey string `json:"api_key"`
+		} `json:"server"`
+	}
+	if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Server.Port != 9123 {
+		t.Errorf("Expected port 9123, got %d", response.Server.Port)
+	}
+	if response.Server.APIKey != redactedValue {
+		t.Errorf("Expected the API key to read %q, got %q", redactedValue, response.Server.APIKey)
+	}
+	if response.Quotient.LogSize != 8 || response.Quotient.VerifyInterval != "1m0s" {
+		t.Errorf("Unexpected quotient settings %+v", response.Quotient)
+	}
+	if Configuration.Server.APIKey != "s3cr3t" {
+		t.Error("Expected the live configuration to keep its API key")
+	}
+}
+
+func TestV1TestAndInsertHandler(t *testing.T) {
+	useTestFilter(t, 8)
+
+	for i, expected := range []bool{true, false} {
+		ctx := newTestRequestCtx("POST", "/v1/test_and_insert", []byte(`{"key":"order-42"}`))
+		v1TestAndInsertHandler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+
+		var response V1TestAndInsertResponse
+		if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.Claimed != expected {
+			t.Errorf("Request %d: expected claimed=%v, got %v", i+1, expected, response.Claimed)
+		}
+	}
+
+	if exists, _ := QF.Exists([]byte("order-42")); !exists {
+		t.Error("Expected the claimed key to be stored")
+	}
+}
+
+func TestV1VerifyHandler(t *testing.T) {
+	qf := useTestFilter(t, 6)
+	for i := 0; i < 40; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	verify := func() (int, V1VerifyResponse) {
+		ctx := newTestRequestCtx("POST", "/v1/verify", nil)
+		v1VerifyHandler(ctx)
+
+		var response V1VerifyResponse
+		if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return ctx.Response.StatusCode(), response
+	}
+
+	if status, response := verify(); status != fasthttp.StatusOK || !response.OK {
+		t.Errorf("Expected a healthy filter to pass, got status %d and %+v", status, response)
+	}
+
+	for slot := uint64(0); slot <= qf.mask; slot++ {
+		if qf.isRunEnd(slot) {
+			qf.clearRunEnd(slot)
+			break
+		}
+	}
+	if status, response := verify(); status != fasthttp.StatusInternalServerError || response.OK || response.Error == "" {
+		t.Errorf("Expected a corrupted filter to fail, got status %d and %+v", status, response)
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	useTestFilter(t, 8)
+	Configuration.Server.UnixSocket = filepath.Join(t.TempDir(), "quotient.sock")
+	Configuration.Server.DisableTCP = true
+
+	server := &fasthttp.Server{Handler: newRequestHandler(Configuration)}
+	served := make(chan error, 1)
+	go func() { served <- serve(server, Configuration) }()
+
+	client := &fasthttp.HostClient{
+		Addr: "quotient",
+		Dial: func(string) (net.Conn, error) {
+			return net.Dial("unix", Configuration.Server.UnixSocket)
+		},
+	}
+
+	var status int
+	var body []byte
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status, body, err = client.Get(nil, "http://quotient/"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Failed to reach the server over the unix socket: %v", err)
+	}
+	if status != fasthttp.StatusOK || string(body) != "Quotient is up and running" {
+		t.Errorf("Unexpected response %d %q", status, body)
+	}
+
+	info, err := os.Stat(Configuration.Server.UnixSocket)
+	if err != nil {
+		t.Fatalf("Failed to stat socket: %v", err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("Expected the socket to have mode 0660, got %v", info.Mode().Perm())
+	}
+
+	if err := server.Shutdown(); err != nil {
+		t.Fatalf("Failed to shut down server: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected serve to return cleanly after shutdown, got %v", err)
+	}
+}
+
+func TestV1RemoveHandlerDelete(t *testing.T) {
+	qf := useTestFilter(t, 8)
+	qf.Insert([]byte("foo"))
+
+	ctx := newTestRequestCtx("DELETE", "/v1/remove?key=foo", nil)
+	v1RemoveHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	var response V1RemoveResponse
+	if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !response.Removed || response.Key != "foo" {
+		t.Errorf("Expected foo to be removed, got %+v", response)
+	}
+	if exists, _ := qf.Exists([]byte("foo")); exists {
+		t.Error("Expected foo to be gone")
+	}
+
+	ctx = newTestRequestCtx("DELETE", "/v1/remove", nil)
+	v1RemoveHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Errorf("Expected status %d without a key, got %d", fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	}
+}
+
+func TestV1CountHandler(t *testing.T) {
+	qf := useTestFilter(t, 8)
+	for i := 0; i < 64; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	ctx := newTestRequestCtx("GET", "/v1/count", nil)
+	v1CountHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fasthttp.StatusOK, ctx.Response.StatusCode())
+	}
+
+	var response V1CountResponse
+	if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Count != 64 || response.Capacity != 1<<8 {
+		t.Errorf("Expected 64 items out of %d, got %+v", 1<<8, response)
+	}
+	if response.LoadFactor != float64(response.Count)/float64(response.Capacity) {
+		t.Errorf("Expected a load factor of count/capacity, got %+v", response)
+	}
+}
+
+func TestV1FirstPresentHandler(t *testing.T) {
+	qf := useTestFilter(t, 8)
+	qf.Insert([]byte("fallback"))
+
+	ctx := newTestRequestCtx("POST", "/v1/first_present", []byte(`{"keys":["preferred","fallback"]}`))
+	v1FirstPresentHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", fasthttp.StatusOK, ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	var response V1FirstPresentResponse
+	if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !response.Found || response.Index != 1 || response.Key != "fallback" {
+		t.Errorf("Expected fallback at index 1, got %+v", response)
+	}
+
+	ctx = newTestRequestCtx("POST", "/v1/first_present", []byte(`{"keys":["preferred",""]}`))
+	v1FirstPresentHandler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Errorf("Expected status %d for an empty key, got %d", fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ShardedFilter spreads keys over several independent QuotientFilters, so
+// that writes are parallelised across more lock stripes and each filter
+// stays small. Keys are routed by the high bits of their hash, while the
+// filters themselves consume the low bits for the quotient.
+type ShardedFilter struct {
+	shards []*QuotientFilter
+}
+
+// NewShardedFilter creates shardCount filters of 2^logSize slots each, all
+// built with opts. A shardCount of zero is treated as one.
+func NewShardedFilter(shardCount uint, logSize uint, opts ...FilterOption) *ShardedFilter {
+	if shardCount == 0 {
+		shardCount = 1
+	}
+
+	shards := make([]*QuotientFilter, shardCount)
+	for i := range shards {
+		shards[i] = NewQuotientFilter(logSize, opts...)
+	}
+
+	return &ShardedFilter{shards: shards}
+}
+
+func (sf *ShardedFilter) Insert(data []byte) error {
+	return sf.shardFor(data).Insert(data)
+}
+
+func (sf *ShardedFilter) Exists(data []byte) (bool, time.Duration) {
+	return sf.shardFor(data).Exists(data)
+}
+
+func (sf *ShardedFilter) Remove(data []byte) bool {
+	return sf.shardFor(data).Remove(data)
+}
+
+// Count returns the number of items stored across all shards.
+func (sf *ShardedFilter) Count() int {
+	total := 0
+	for _, shard := range sf.shards {
+		total += shard.Count()
+	}
+	return total
+}
+
+// WriteTo writes the number of shards followed by the export of every
+// shard, in order, as written by QuotientFilter.WriteTo. Each shard is copied
+// under its own locks, so writes to a shard already exported may land while
+// the next one is copied.
+func (sf *ShardedFilter) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	if err := binary.Write(counter, binary.LittleEndian, uint32(len(sf.shards))); err != nil {
+		return counter.n, err
+	}
+	for _, shard := range sf.shards {
+		if _, err := shard.WriteTo(counter); err != nil {
+			return counter.n, err
+		}
+	}
+	return counter.n, nil
+}
+
+// ReadFrom replaces the content of every shard with an export written by
+// WriteTo, which must have the same number of shards. Every shard export is
+// read and checked as by QuotientFilter.ReadFrom before any shard changes,
+// so a failed import leaves all of them untouched.
+func (sf *ShardedFilter) ReadFrom(r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+
+	var shardCount uint32
+	if err := binary.Read(counter, binary.LittleEndian, &shardCount); err != nil {
+		return counter.n, fmt.Errorf("could not read shard count: %w", err)
+	}
+	if int(shardCount) != len(sf.shards) {
+		return counter.n, fmt.Errorf("%w: written with %d shards, expected %d", ErrFilterMismatch, shardCount, len(sf.shards))
+	}
+
+	imports := make([]*filterImport, len(sf.shards))
+	for i, shard := range sf.shards {
+		imp, err := shard.readImport(counter)
+		if err != nil {
+			return counter.n, fmt.Errorf("shard %d: %w", i, err)
+		}
+		imports[i] = imp
+	}
+
+	for _, shard := range sf.shards {
+		shard.lockAll()
+		defer shard.unlockAll()
+	}
+	for i, shard := range sf.shards {
+		if err := imports[i].header.check(shard); err != nil {
+			return counter.n, fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	for i, shard := range sf.shards {
+		// Cannot fail: every header was checked above, under the same locks.
+		shard.applyImportUnsafe(imports[i])
+	}
+	return counter.n, nil
+}
+
+func (sf *ShardedFilter) shardFor(data []byte) *QuotientFilter {
+	return sf.shards[sf.shardIndex(data)]
+}
+
+// shardIndex maps the high 32 bits of the key hash onto [0, len(shards))
+// with a multiply-shift range reduction, which avoids a modulo and keeps the
+// low bits untouched for the quotient. FNV-1a barely diffuses short keys into
+// its high bits, so the hash goes through a finalizer first.
+func (sf *ShardedFilter) shardIndex(data []byte) int {
+	shard := sf.shards[0]
+	high := mix64(shard.hashKey(shard.normalize(data))) >> 32
+	return int((high * uint64(len(sf.shards))) >> 32)
+}
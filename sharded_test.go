@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestShardedFilterRouting(t *testing.T) {
+	sf := NewShardedFilter(4, 10)
+
+	keys := make([][]byte, 200)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+		if err := sf.Insert(keys[i]); err != nil {
+			t.Fatalf("Failed to insert %s: %v", keys[i], err)
+		}
+	}
+
+	perShard := make([]int, len(sf.shards))
+	for _, key := range keys {
+		index := sf.shardIndex(key)
+		perShard[index]++
+
+		for i, shard := range sf.shards {
+			exists, _ := shard.Exists(key)
+			if i == index && !exists {
+				t.Errorf("Key %s should be stored in shard %d, but isn't", key, i)
+			}
+		}
+
+		if exists, _ := sf.Exists(key); !exists {
+			t.Errorf("Key %s should exist in the sharded filter, but doesn't", key)
+		}
+	}
+
+	for i, shard := range sf.shards {
+		if perShard[i] == 0 {
+			t.Errorf("Shard %d received no keys", i)
+		}
+		if shard.Count() != perShard[i] {
+			t.Errorf("Shard %d: expected %d items, but found %d", i, perShard[i], shard.Count())
+		}
+	}
+
+	if sf.Count() != len(keys) {
+		t.Errorf("Expected Count to aggregate to %d, but got %d", len(keys), sf.Count())
+	}
+
+	if !sf.Remove(keys[0]) {
+		t.Errorf("Failed to remove %s", keys[0])
+	}
+	if sf.Count() != len(keys)-1 {
+		t.Errorf("Expected %d items after removal, but got %d", len(keys)-1, sf.Count())
+	}
+}
+
+func TestShardedFilterExportImport(t *testing.T) {
+	source := NewShardedFilter(4, 8)
+	for i := 0; i < 400; i++ {
+		if err := source.Insert([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("Failed to insert key-%d: %v", i, err)
+		}
+	}
+
+	var export bytes.Buffer
+	written, err := source.WriteTo(&export)
+	if err != nil {
+		t.Fatalf("Failed to export filter: %v", err)
+	}
+	if written != int64(export.Len()) {
+		t.Errorf("WriteTo reported %d bytes, but wrote %d", written, export.Len())
+	}
+
+	target := NewShardedFilter(4, 8)
+	target.Insert([]byte("overwritten"))
+	if _, err := target.ReadFrom(bytes.NewReader(export.Bytes())); err != nil {
+		t.Fatalf("Failed to import filter: %v", err)
+	}
+	for i, shard := range target.shards {
+		if shard.Checksum() != source.shards[i].Checksum() {
+			t.Errorf("Shard %d does not hold the same items as the exported one", i)
+		}
+	}
+	for i := 0; i < 400; i++ {
+		if exists, _ := target.Exists([]byte(fmt.Sprintf("key-%d", i))); !exists {
+			t.Errorf("key-%d should exist after import, but doesn't", i)
+		}
+	}
+
+	if _, err := NewShardedFilter(2, 8).ReadFrom(bytes.NewReader(export.Bytes())); !errors.Is(err, ErrFilterMismatch) {
+		t.Errorf("Expected a shard count mismatch, got %v", err)
+	}
+
+	// The last shard is cut short, so none of them may change.
+	kept := NewShardedFilter(4, 8)
+	kept.Insert([]byte("kept"))
+	if _, err := kept.ReadFrom(bytes.NewReader(export.Bytes()[:export.Len()-100])); err == nil {
+		t.Fatal("Expected a truncated export to be rejected")
+	}
+	if exists, _ := kept.Exists([]byte("kept")); !exists || kept.Count() != 1 {
+		t.Error("A failed import should leave every shard untouched")
+	}
+}
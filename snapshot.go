@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// filterFormatVersion is bumped whenever the persisted layout changes.
+// Version 2 adds the sections that follow the slots of an export; the slots
+// themselves, and mmap files, are laid out as in version 1, which is still
+// read.
+const filterFormatVersion = 2
+
+// Sections an export carries after its slots, as a bit set, from format
+// version 2 on.
+const (
+	sectionKeys uint32 = 1 << iota // Retained keys, grouped by fingerprint
+	sectionFIFO                    // Insertion order, oldest first
+)
+
+var filterMagic = [8]byte{'Q', 'U', 'O', 'T', 'I', 'E', 'N', 'T'}
+
+// ErrFilterMismatch is returned, wrapped, when a persisted filter was written
+// with another logSize, hash algorithm or hash seed than the filter about to
+// use it.
+var ErrFilterMismatch = errors.New("filter settings mismatch")
+
+// filterHeader precedes every persisted slot array, both in mmap files and
+// in exports, and records how the slots were written.
+type filterHeader struct {
+	Magic     [8]byte
+	Version   uint32
+	LogSize   uint32
+	Algorithm [32]byte
+	Seed      uint32
+}
+
+func newFilterHeader(qf *QuotientFilter) filterHeader {
+	header := filterHeader{
+		Magic:   filterMagic,
+		Version: filterFormatVersion,
+		LogSize: uint32(qf.quotient),
+		Seed:    qf.seed,
+	}
+	copy(header.Algorithm[:], qf.algorithm)
+	return header
+}
+
+// check rejects a header that does not match the filter about to use it.
+// Restoring under a different hash algorithm would silently turn every stored
+// item into a false negative.
+func (h filterHeader) check(qf *QuotientFilter) error {
+	if h.Magic != filterMagic {
+		return fmt.Errorf("not a quotient filter")
+	}
+	if h.Version < 1 || h.Version > filterFormatVersion {
+		return fmt.Errorf("unsupported format version %d, expected at most %d", h.Version, filterFormatVersion)
+	}
+	if uint(h.LogSize) != qf.quotient {
+		return fmt.Errorf("%w: written with logSize %d, expected %d", ErrFilterMismatch, h.LogSize, qf.quotient)
+	}
+	if algorithm := HashAlgorithm(bytes.TrimRight(h.Algorithm[:], "\x00")); algorithm != qf.algorithm {
+		return fmt.Errorf("%w: written with hash algorithm %q, expected %q", ErrFilterMismatch, algorithm, qf.algorithm)
+	}
+	// FNV-1a ignores the seed, so it cannot make the slots incompatible.
+	if qf.algorithm == HashMurmur3 && h.Seed != qf.seed {
+		return fmt.Errorf("%w: written with hash seed %d, expected %d", ErrFilterMismatch, h.Seed, qf.seed)
+	}
+	return nil
+}
+
+// WriteTo writes the filter header followed by every slot, then the retained
+// keys of a key-retaining filter and the insertion order of a FIFO filter.
+// They are copied under all stripe read locks and written after releasing
+// them, so a slow writer does not hold up inserts.
+func (qf *QuotientFilter) WriteTo(w io.Writer) (int64, error) {
+	var keys *keyStore
+	var fifo *fifoTracker
+
+	qf.rLockAll()
+	header := newFilterHeader(qf)
+	data := make([]uint64, len(qf.data))
+	for slot := range qf.data {
+		data[slot] = atomic.LoadUint64(&qf.data[slot])
+	}
+	if qf.keys != nil {
+		keys = qf.keys.clone()
+	}
+	if qf.fifo != nil {
+		fifo = qf.fifo.clone()
+	}
+	qf.rUnlockAll()
+
+	counter := &countingWriter{w: w}
+	if err := binary.Write(counter, binary.LittleEndian, header); err != nil {
+		return counter.n, err
+	}
+	if err := binary.Write(counter, binary.LittleEndian, data); err != nil {
+		return counter.n, err
+	}
+	err := writeSections(counter, keys, fifo)
+	return counter.n, err
+}
+
+// exportedKey precedes every retained key in an export.
+type exportedKey struct {
+	Fingerprint Fingerprint
+	Length      uint32
+}
+
+// writeSections writes the bit set of the sections that follow, then the
+// keys grouped by fingerprint, then the fingerprints from oldest to newest.
+// Either may be nil.
+func writeSections(w io.Writer, keys *keyStore, fifo *fifoTracker) error {
+	var sections uint32
+	if keys != nil {
+		sections |= sectionKeys
+	}
+	if fifo != nil {
+		sections |= sectionFIFO
+	}
+	if err := binary.Write(w, binary.LittleEndian, sections); err != nil {
+		return err
+	}
+
+	if keys != nil {
+		total := 0
+		for _, group := range keys.keys {
+			total += len(group)
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint64(total)); err != nil {
+			return err
+		}
+		for fp, group := range keys.keys {
+			for _, key := range group {
+				if err := binary.Write(w, binary.LittleEndian, exportedKey{fp, uint32(len(key))}); err != nil {
+					return err
+				}
+				if _, err := io.WriteString(w, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if fifo != nil {
+		order := fifo.order()
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(order))); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSections reads what writeSections wrote. The counts come from the
+// export, so entries are read one at a time instead of allocated up front.
+func readSections(r io.Reader) (keys *keyStore, fifo []Fingerprint, err error) {
+	var sections uint32
+	if err := binary.Read(r, binary.LittleEndian, &sections); err != nil {
+		return nil, nil, err
+	}
+
+	var total uint64
+	if sections&sectionKeys != 0 {
+		if err := binary.Read(r, binary.LittleEndian, &total); err != nil {
+			return nil, nil, err
+		}
+		keys = newKeyStore()
+		for ; total > 0; total-- {
+			var entry exportedKey
+			if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+				return nil, nil, err
+			}
+			var key bytes.Buffer
+			if _, err := io.CopyN(&key, r, int64(entry.Length)); err != nil {
+				return nil, nil, err
+			}
+			keys.add(entry.Fingerprint, key.Bytes())
+		}
+	}
+
+	if sections&sectionFIFO != 0 {
+		if err := binary.Read(r, binary.LittleEndian, &total); err != nil {
+			return nil, nil, err
+		}
+		fifo = []Fingerprint{}
+		for ; total > 0; total-- {
+			var fp Fingerprint
+			if err := binary.Read(r, binary.LittleEndian, &fp); err != nil {
+				return nil, nil, err
+			}
+			fifo = append(fifo, fp)
+		}
+	}
+	return keys, fifo, nil
+}
+
+// ReadFrom replaces the content of the filter with an export written by
+// WriteTo. The export must have been written with the same logSize, hash
+// algorithm and seed, and must pass Verify; otherwise the filter is left
+// untouched. A key-retaining filter also replaces its keys, and a FIFO
+// filter its insertion order, so they only take exports of filters of the
+// same kind. Keys and order in an export are ignored by filters that do not
+// keep them.
+func (qf *QuotientFilter) ReadFrom(r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+	imp, err := qf.readImport(counter)
+	if err != nil {
+		return counter.n, err
+	}
+
+	qf.lockAll()
+	defer qf.unlockAll()
+	return counter.n, qf.applyImportUnsafe(imp)
+}
+
+// filterImport is an export read and checked against a filter, ready to
+// replace its content.
+type filterImport struct {
+	header   filterHeader
+	imported *QuotientFilter
+	keys     *keyStore    // Only set for a key-retaining filter
+	fifo     *fifoTracker // Only set for a FIFO filter
+}
+
+// readImport reads an export for qf and checks it, without changing qf or
+// holding its locks while reading.
+func (qf *QuotientFilter) readImport(r io.Reader) (*filterImport, error) {
+	var header filterHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	qf.rLockAll()
+	err := header.check(qf)
+	qf.rUnlockAll()
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &filterImport{header: header}
+	imp.imported = newQuotientFilter(make([]uint64, 1<<header.LogSize), uint(header.LogSize), nil)
+	if err := binary.Read(r, binary.LittleEndian, imp.imported.data); err != nil {
+		return nil, fmt.Errorf("could not read slots: %w", err)
+	}
+	imp.imported.count.Store(imp.imported.countEntries())
+	if err := imp.imported.Verify(); err != nil {
+		return nil, fmt.Errorf("corrupt filter: %w", err)
+	}
+
+	var keys *keyStore
+	var order []Fingerprint
+	if header.Version >= 2 {
+		if keys, order, err = readSections(r); err != nil {
+			return nil, fmt.Errorf("could not read keys and insertion order: %w", err)
+		}
+	}
+	if qf.keys != nil {
+		if keys == nil {
+			return nil, fmt.Errorf("%w: written without retained keys, expected a key-retaining filter", ErrFilterMismatch)
+		}
+		if err := qf.checkImportedKeys(imp.imported, keys); err != nil {
+			return nil, fmt.Errorf("corrupt filter: %w", err)
+		}
+		imp.keys = keys
+	}
+	if qf.fifo != nil {
+		if order == nil {
+			return nil, fmt.Errorf("%w: written without insertion order, expected a FIFO filter", ErrFilterMismatch)
+		}
+		if imp.fifo, err = importedOrder(imp.imported, order); err != nil {
+			return nil, fmt.Errorf("corrupt filter: %w", err)
+		}
+	}
+	return imp, nil
+}
+
+// applyImportUnsafe replaces the content of qf with imp. The caller must
+// hold every stripe lock.
+func (qf *QuotientFilter) applyImportUnsafe(imp *filterImport) error {
+	// The export was read without holding any lock, so the filter may have
+	// been resized meanwhile.
+	if err := imp.header.check(qf); err != nil {
+		return err
+	}
+	for slot, word := range imp.imported.data {
+		atomic.StoreUint64(&qf.data[slot], word)
+	}
+	qf.count.Store(imp.imported.count.Load())
+	if qf.keys != nil {
+		qf.keys.replace(imp.keys)
+	}
+	if qf.fifo != nil {
+		qf.fifo.replace(imp.fifo)
+	}
+	if qf.negatives != nil {
+		qf.negatives.clear()
+	}
+	qf.audit.RecordFilter("import")
+	return nil
+}
+
+// checkImportedKeys checks that every key hashes to the fingerprint it was
+// exported under, that the fingerprint is stored in imported, and that every
+// stored fingerprint has a key.
+func (qf *QuotientFilter) checkImportedKeys(imported *QuotientFilter, keys *keyStore) error {
+	for fp, group := range keys.keys {
+		for _, key := range group {
+			if quotient, remainder := qf.split(qf.hashKey([]byte(key))); (Fingerprint{quotient, remainder}) != fp {
+				return fmt.Errorf("key %q is not stored under its fingerprint", key)
+			}
+		}
+		if !imported.existsUnsafe(fp.Quotient, fp.Remainder) {
+			return fmt.Errorf("key %q has no stored fingerprint", group[0])
+		}
+	}
+	if len(keys.keys) != imported.Count() {
+		return fmt.Errorf("%d fingerprints have keys, but %d are stored", len(keys.keys), imported.Count())
+	}
+	return nil
+}
+
+// importedOrder rebuilds the insertion order of imported from the
+// fingerprints of an export, oldest first, which must each be stored in
+// imported exactly once.
+func importedOrder(imported *QuotientFilter, order []Fingerprint) (*fifoTracker, error) {
+	fifo := newFIFOTracker()
+	for _, fp := range order {
+		if _, ok := fifo.latest[fp]; ok {
+			return nil, fmt.Errorf("fingerprint %+v appears twice in the insertion order", fp)
+		}
+		if !imported.existsUnsafe(fp.Quotient, fp.Remainder) {
+			return nil, fmt.Errorf("fingerprint %+v in the insertion order is not stored", fp)
+		}
+		fifo.added(fp)
+	}
+	if len(fifo.latest) != imported.Count() {
+		return nil, fmt.Errorf("the insertion order has %d fingerprints, but %d are stored", len(fifo.latest), imported.Count())
+	}
+	return fifo, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotientFilterExportImport(t *testing.T) {
+	source := NewQuotientFilter(10)
+	for i := 0; i < 500; i++ {
+		source.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	var export bytes.Buffer
+	written, err := source.WriteTo(&export)
+	if err != nil {
+		t.Fatalf("Failed to export filter: %v", err)
+	}
+	if written != int64(export.Len()) {
+		t.Errorf("WriteTo reported %d bytes, but wrote %d", written, export.Len())
+	}
+
+	target := NewQuotientFilter(10)
+	target.Insert([]byte("overwritten"))
+	if _, err := target.ReadFrom(bytes.NewReader(export.Bytes())); err != nil {
+		t.Fatalf("Failed to import filter: %v", err)
+	}
+
+	if target.Count() != source.Count() {
+		t.Errorf("Expected %d items after import, but found %d", source.Count(), target.Count())
+	}
+	if target.Checksum() != source.Checksum() {
+		t.Error("Imported filter does not hold the same items as the exported one")
+	}
+	for i := 0; i < 500; i++ {
+		if exists, _ := target.Exists([]byte(fmt.Sprintf("item%d", i))); !exists {
+			t.Errorf("item%d should exist after import, but doesn't", i)
+		}
+	}
+
+	t.Run("Rejects incompatible filters", func(t *testing.T) {
+		incompatible := map[string]*QuotientFilter{
+			"logSize":        NewQuotientFilter(11),
+			"hash algorithm": NewQuotientFilter(10, WithHashAlgorithm(HashFNV)),
+			"hash seed":      NewQuotientFilter(10, WithHashSeed(1)),
+		}
+		for reason, qf := range incompatible {
+			_, err := qf.ReadFrom(bytes.NewReader(export.Bytes()))
+			if !errors.Is(err, ErrFilterMismatch) || !strings.Contains(err.Error(), reason) {
+				t.Errorf("Expected a %s mismatch error, got %v", reason, err)
+			}
+		}
+	})
+
+	t.Run("Ignores the seed with FNV-1a", func(t *testing.T) {
+		source := NewQuotientFilter(10, WithHashAlgorithm(HashFNV), WithHashSeed(1))
+		source.Insert([]byte("fnv"))
+		var fnvExport bytes.Buffer
+		if _, err := source.WriteTo(&fnvExport); err != nil {
+			t.Fatalf("Failed to export filter: %v", err)
+		}
+
+		qf := NewQuotientFilter(10, WithHashAlgorithm(HashFNV))
+		if _, err := qf.ReadFrom(&fnvExport); err != nil {
+			t.Fatalf("Expected an FNV-1a export to import under another seed, got %v", err)
+		}
+		if exists, _ := qf.Exists([]byte("fnv")); !exists {
+			t.Error("Expected the imported key to exist")
+		}
+	})
+
+	t.Run("Rejects truncated exports", func(t *testing.T) {
+		qf := NewQuotientFilter(10)
+		qf.Insert([]byte("kept"))
+		if _, err := qf.ReadFrom(bytes.NewReader(export.Bytes()[:export.Len()/2])); err == nil {
+			t.Fatal("Expected a truncated export to be rejected")
+		}
+		if exists, _ := qf.Exists([]byte("kept")); !exists || qf.Count() != 1 {
+			t.Error("A failed import should leave the filter untouched")
+		}
+	})
+}
+
+func TestQuotientFilterImportRecountsEntries(t *testing.T) {
+	source := NewQuotientFilter(10)
+	for i := 0; i < 300; i++ {
+		source.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+	expected := source.Count()
+
+	// A writer with a count gone wrong, importing into a filter whose own
+	// count has drifted too. Exports carry no count, so neither can leak into
+	// the imported filter.
+	source.count.Add(37)
+	var export bytes.Buffer
+	if _, err := source.WriteTo(&export); err != nil {
+		t.Fatalf("Failed to export filter: %v", err)
+	}
+
+	target := NewQuotientFilter(10)
+	target.count.Store(-5)
+	if _, err := target.ReadFrom(&export); err != nil {
+		t.Fatalf("Failed to import filter: %v", err)
+	}
+	if target.Count() != expected {
+		t.Errorf("Expected the import to recount %d items, got %d", expected, target.Count())
+	}
+	if err := target.Verify(); err != nil {
+		t.Errorf("Imported filter does not verify: %v", err)
+	}
+}
+
+// blockingWriter stands in for a slow client: its first Write reports on
+// started, then waits for release.
+type blockingWriter struct {
+	bytes.Buffer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (bw *blockingWriter) Write(p []byte) (int, error) {
+	if bw.started != nil {
+		close(bw.started)
+		bw.started = nil
+		<-bw.release
+	}
+	return bw.Buffer.Write(p)
+}
+
+func TestQuotientFilterExportDoesNotBlockInserts(t *testing.T) {
+	qf := NewQuotientFilter(10)
+	for i := 0; i < 100; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	writer := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	started := writer.started
+	exported := make(chan error, 1)
+	go func() {
+		_, err := qf.WriteTo(writer)
+		exported <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := qf.InsertContext(ctx, []byte("during-export")); err != nil {
+		t.Fatalf("Insert should not wait for a slow export, got %v", err)
+	}
+	select {
+	case <-exported:
+		t.Fatal("Expected the export to still be blocked on its writer")
+	default:
+	}
+
+	close(writer.release)
+	if err := <-exported; err != nil {
+		t.Fatalf("Failed to export filter: %v", err)
+	}
+
+	// The export is the filter as it was when the slots were copied, before
+	// the insert.
+	imported := NewQuotientFilter(10)
+	if _, err := imported.ReadFrom(&writer.Buffer); err != nil {
+		t.Fatalf("Failed to import filter: %v", err)
+	}
+	if imported.Count() != 100 {
+		t.Errorf("Expected 100 items in the export, got %d", imported.Count())
+	}
+	if exists, _ := imported.Exists([]byte("during-export")); exists {
+		t.Error("An item inserted after the copy should not be in the export")
+	}
+}
+
+func TestQuotientFilterExportImportKeys(t *testing.T) {
+	source := NewKeyRetainingQuotientFilter(10)
+	for i := 0; i < 300; i++ {
+		source.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+	for i := 0; i < 300; i += 3 {
+		source.Remove([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	var export bytes.Buffer
+	if _, err := source.WriteTo(&export); err != nil {
+		t.Fatalf("Failed to export filter: %v", err)
+	}
+
+	target := NewKeyRetainingQuotientFilter(10)
+	target.Insert([]byte("overwritten"))
+	if _, err := target.ReadFrom(bytes.NewReader(export.Bytes())); err != nil {
+		t.Fatalf("Failed to import filter: %v", err)
+	}
+	if target.Checksum() != source.Checksum() {
+		t.Error("Imported filter does not hold the same items as the exported one")
+	}
+	expected, _ := source.KeysAfter(nil, 1000)
+	imported, _ := target.KeysAfter(nil, 1000)
+	if fmt.Sprint(imported) != fmt.Sprint(expected) {
+		t.Errorf("Expected the imported keys to be %s, got %s", expected, imported)
+	}
+	if !target.Remove([]byte("item1")) || target.Remove([]byte("item3")) {
+		t.Error("Expected the imported keys to drive removals")
+	}
+
+	t.Run("Ignored by a regular filter", func(t *testing.T) {
+		plain := NewQuotientFilter(10)
+		if _, err := plain.ReadFrom(bytes.NewReader(export.Bytes())); err != nil {
+			t.Fatalf("Failed to import filter: %v", err)
+		}
+		if plain.Checksum() != source.Checksum() {
+			t.Error("Imported filter does not hold the same items as the exported one")
+		}
+	})
+
+	t.Run("Required by a key-retaining filter", func(t *testing.T) {
+		var plainExport bytes.Buffer
+		if _, err := NewQuotientFilter(10).WriteTo(&plainExport); err != nil {
+			t.Fatalf("Failed to export filter: %v", err)
+		}
+		if _, err := target.ReadFrom(&plainExport); !errors.Is(err, ErrFilterMismatch) {
+			t.Errorf("Expected a mismatch error, got %v", err)
+		}
+	})
+
+	t.Run("Rejects keys under the wrong fingerprint", func(t *testing.T) {
+		corrupt := bytes.Replace(export.Bytes(), []byte("item2"), []byte("itex2"), 1)
+		qf := NewKeyRetainingQuotientFilter(10)
+		qf.Insert([]byte("kept"))
+		if _, err := qf.ReadFrom(bytes.NewReader(corrupt)); err == nil || errors.Is(err, ErrFilterMismatch) {
+			t.Fatalf("Expected a corrupt export to be rejected, got %v", err)
+		}
+		if keys := qf.Keys(); len(keys) != 1 || string(keys[0]) != "kept" {
+			t.Errorf("A failed import should leave the keys untouched, got %s", keys)
+		}
+	})
+}
+
+func TestQuotientFilterExportImportFIFO(t *testing.T) {
+	source := NewQuotientFilter(4, WithFIFOEviction())
+	for i := 0; i < 20; i++ {
+		source.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	var export bytes.Buffer
+	if _, err := source.WriteTo(&export); err != nil {
+		t.Fatalf("Failed to export filter: %v", err)
+	}
+	target := NewQuotientFilter(4, WithFIFOEviction())
+	if _, err := target.ReadFrom(&export); err != nil {
+		t.Fatalf("Failed to import filter: %v", err)
+	}
+
+	// The oldest item still stored is the first one evicted after import.
+	target.Insert([]byte("newest"))
+	if exists, _ := target.Exists([]byte("item4")); exists {
+		t.Error("Expected item4, the oldest item, to be evicted")
+	}
+	for i := 5; i < 20; i++ {
+		if exists, _ := target.Exists([]byte(fmt.Sprintf("item%d", i))); !exists {
+			t.Errorf("item%d should still exist", i)
+		}
+	}
+
+	var plainExport bytes.Buffer
+	if _, err := NewQuotientFilter(4).WriteTo(&plainExport); err != nil {
+		t.Fatalf("Failed to export filter: %v", err)
+	}
+	if _, err := target.ReadFrom(&plainExport); !errors.Is(err, ErrFilterMismatch) {
+		t.Errorf("Expected a FIFO filter to reject an export without insertion order, got %v", err)
+	}
+}
+
+func TestQuotientFilterImportVersion1(t *testing.T) {
+	source := NewQuotientFilter(8)
+	for i := 0; i < 100; i++ {
+		source.Insert([]byte(fmt.Sprintf("item%d", i)))
+	}
+
+	var export bytes.Buffer
+	if _, err := source.WriteTo(&export); err != nil {
+		t.Fatalf("Failed to export filter: %v", err)
+	}
+
+	// A version 1 export ends with the slots: drop the section bit set and
+	// rewrite the version, which follows the magic.
+	legacy := export.Bytes()[:export.Len()-4]
+	legacy[8] = 1
+
+	target := NewQuotientFilter(8)
+	if _, err := target.ReadFrom(bytes.NewReader(legacy)); err != nil {
+		t.Fatalf("Failed to import a version 1 export: %v", err)
+	}
+	if target.Checksum() != source.Checksum() {
+		t.Error("Imported filter does not hold the same items as the exported one")
+	}
+}
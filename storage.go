@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+// SlotStorage is the memory backing the slot array of a filter, so that it
+// can live somewhere other than the Go heap. The filter works on the slice
+// directly with atomic loads and compare-and-swaps: a method call per slot
+// would cost more than the lookups themselves, so a backend only decides
+// where the slots live.
+type SlotStorage interface {
+	// Slots returns the slot array. It must not change until Close.
+	Slots() []uint64
+	// Close releases the memory. The filter no longer touches the slots once
+	// it has called Close.
+	Close() error
+}
+
+type heapStorage []uint64
+
+// NewHeapStorage allocates 2^logSize zeroed slots on the Go heap, as
+// NewQuotientFilter does.
+func NewHeapStorage(logSize uint) SlotStorage {
+	return heapStorage(make([]uint64, uint64(1)<<logSize))
+}
+
+func (s heapStorage) Slots() []uint64 { return s }
+
+func (s heapStorage) Close() error { return nil }
+
+// NewQuotientFilterWithStorage creates a filter of 2^logSize slots on top of
+// storage, which must hold exactly that many. Slots that are already filled
+// are kept. Closing the filter closes the storage, and the filter cannot be
+// resized, since the storage is sized for logSize.
+func NewQuotientFilterWithStorage(logSize uint, storage SlotStorage, opts ...FilterOption) (*QuotientFilter, error) {
+	if err := checkLogSize(logSize); err != nil {
+		return nil, err
+	}
+
+	data := storage.Slots()
+	if size := uint64(1) << logSize; uint64(len(data)) != size {
+		return nil, fmt.Errorf("storage holds %d slots, expected %d for logSize %d", len(data), size, logSize)
+	}
+
+	qf := newQuotientFilter(data, logSize, opts)
+	qf.count.Store(qf.countEntries())
+	qf.release = func() error {
+		qf.data = nil
+		return storage.Close()
+	}
+	return qf, nil
+}
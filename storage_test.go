@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// testFilterCorrectness fills qf to three quarters, removes every other key
+// and checks membership, Count and the structural invariants along the way,
+// so that alternative storages can be held to the same behaviour.
+func testFilterCorrectness(t *testing.T, qf *QuotientFilter) {
+	t.Helper()
+
+	n := qf.Capacity() * 3 / 4
+	for i := 0; i < n; i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+	if qf.Count() != n {
+		t.Fatalf("Expected %d items, but found %d", n, qf.Count())
+	}
+	if err := qf.Verify(); err != nil {
+		t.Fatalf("Filter is corrupted after inserting: %v", err)
+	}
+
+	for i := 0; i < n; i += 2 {
+		if !qf.Remove([]byte(fmt.Sprintf("item%d", i))) {
+			t.Fatalf("Failed to remove item%d", i)
+		}
+	}
+	if err := qf.Verify(); err != nil {
+		t.Fatalf("Filter is corrupted after removing: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		exists, _ := qf.Exists([]byte(fmt.Sprintf("item%d", i)))
+		if removed := i%2 == 0; exists == removed {
+			t.Errorf("Expected item%d to exist: %v, got %v", i, !removed, exists)
+		}
+	}
+}
+
+func TestQuotientFilterWithHeapStorage(t *testing.T) {
+	qf, err := NewQuotientFilterWithStorage(10, NewHeapStorage(10))
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	testFilterCorrectness(t, qf)
+
+	if err := qf.Resize(11); err == nil {
+		t.Error("Expected resizing a filter with custom storage to fail")
+	}
+	if err := qf.Close(); err != nil {
+		t.Errorf("Failed to close filter: %v", err)
+	}
+
+	if _, err := NewQuotientFilterWithStorage(11, NewHeapStorage(10)); err == nil {
+		t.Error("Expected storage of the wrong size to be rejected")
+	}
+}
+
+func TestQuotientFilterWithRefilledStorage(t *testing.T) {
+	storage := NewHeapStorage(8)
+	first, err := NewQuotientFilterWithStorage(8, storage)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	first.Insert([]byte("alpha"))
+	first.Insert([]byte("beta"))
+
+	second, err := NewQuotientFilterWithStorage(8, storage)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	if second.Count() != 2 {
+		t.Errorf("Expected 2 items in filled storage, but found %d", second.Count())
+	}
+	if exists, _ := second.Exists([]byte("alpha")); !exists {
+		t.Error("Expected alpha to exist in filled storage")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultStripes = 16   // Stripe locks of a filter unless WithStripeCount is used
+	maxStripes     = 1024 // Upper bound of WithStripeCount and AutoStripeCount
+
+	stripeCalibration = 5 * time.Millisecond // Time AutoStripeCount spends on each candidate
+)
+
+// stripeCounters tracks how often a stripe lock was taken and how long
+// callers waited for it.
+type stripeCounters struct {
+	acquisitions atomic.Uint64
+	waitNanos    atomic.Int64
+}
+
+// StripeStat is a snapshot of the lock counters of one stripe.
+type StripeStat struct {
+	Stripe       int           `json:"stripe"`
+	Acquisitions uint64        `json:"acquisitions"`
+	Wait         time.Duration `json:"wait"`
+}
+
+// WithStripeCount splits the filter into count lock stripes, rounded up to a
+// power of two and capped at maxStripes. More stripes let more concurrent
+// writers through at the cost of slower whole-filter scans.
+func WithStripeCount(count uint) FilterOption {
+	return func(qf *QuotientFilter) {
+		stripes := uint(1)
+		for stripes < count && stripes < maxStripes {
+			stripes <<= 1
+		}
+		qf.locks = make([]sync.RWMutex, stripes)
+	}
+}
+
+// StripeCount returns the number of lock stripes of the filter.
+func (qf *QuotientFilter) StripeCount() uint {
+	return uint(len(qf.locks))
+}
+
+// AutoStripeCount picks a stripe count for concurrency goroutines, or
+// GOMAXPROCS when concurrency is not positive, by briefly hammering stripe
+// locks at every power of two from 1 up to four times the concurrency and
+// keeping the count with the highest throughput. It takes a few
+// milliseconds per candidate, so it is meant to run once at startup.
+func AutoStripeCount(concurrency int) uint {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	best, bestOps := uint(1), uint64(0)
+	for count := uint(1); count <= maxStripes && count <= 4*uint(concurrency); count <<= 1 {
+		if ops := benchmarkStripes(count, concurrency); ops > bestOps {
+			best, bestOps = count, ops
+		}
+	}
+	return best
+}
+
+// benchmarkStripes counts the lock and unlock pairs that concurrency
+// goroutines manage over count stripes during stripeCalibration.
+func benchmarkStripes(count uint, concurrency int) uint64 {
+	locks := make([]sync.RWMutex, count)
+	slots := make([]uint64, count)
+	mask := uint64(count - 1)
+
+	var ops atomic.Uint64
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func(seed uint64) {
+			defer wg.Done()
+			var done uint64
+			for i := seed; !stop.Load(); i++ {
+				stripe := mix64(i) & mask
+				locks[stripe].Lock()
+				slots[stripe]++
+				locks[stripe].Unlock()
+				done++
+			}
+			ops.Add(done)
+		}(uint64(worker) << 32)
+	}
+
+	time.Sleep(stripeCalibration)
+	stop.Store(true)
+	wg.Wait()
+	return ops.Load()
+}
+
+// WithStripeStats counts lock acquisitions and wait time per stripe, to tell
+// a few hot stripes apart from uniform contention. It costs two clock reads
+// per lock, so it is off by default.
+func WithStripeStats() FilterOption {
+	return func(qf *QuotientFilter) {
+		// Sized once every option has run, see newQuotientFilter.
+		qf.stripeStats = []stripeCounters{}
+	}
+}
+
+// StripeStats returns the counters of every stripe, or nil when the filter
+// was not created with WithStripeStats. Locks taken to scan the whole filter
+// are not counted.
+func (qf *QuotientFilter) StripeStats() []StripeStat {
+	if qf.stripeStats == nil {
+		return nil
+	}
+
+	stats := make([]StripeStat, len(qf.stripeStats))
+	for i := range qf.stripeStats {
+		stats[i] = StripeStat{
+			Stripe:       i,
+			Acquisitions: qf.stripeStats[i].acquisitions.Load(),
+			Wait:         time.Duration(qf.stripeStats[i].waitNanos.Load()),
+		}
+	}
+	return stats
+}
+
+// stripeClock returns the time a lock attempt started, or the zero time when
+// stripe stats are off.
+func (qf *QuotientFilter) stripeClock() time.Time {
+	if qf.stripeStats == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// recordStripeLock accounts for a lock on the stripe owning index, acquired
+// after an attempt that started at start.
+func (qf *QuotientFilter) recordStripeLock(index uint64, start time.Time) {
+	if qf.stripeStats == nil {
+		return
+	}
+
+	counters := &qf.stripeStats[qf.stripe(index)]
+	counters.acquisitions.Add(1)
+	counters.waitNanos.Add(int64(time.Since(start)))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQuotientFilterStripeStats(t *testing.T) {
+	if stats := NewQuotientFilter(10).StripeStats(); stats != nil {
+		t.Errorf("Expected no stripe stats unless enabled, got %v", stats)
+	}
+
+	qf := NewQuotientFilter(10, WithStripeStats())
+
+	// Only touch keys owned by stripe 3, as clustering on a few quotients
+	// would. Each key gets its own quotient, so that no cluster spans more
+	// than its slot and the empty slot after it, owned by stripe 4.
+	const hotStripe = 3
+	var keys [][]byte
+	quotients := make(map[uint64]bool)
+	for i := 0; len(keys) < 40; i++ {
+		key := []byte(fmt.Sprintf("item%d", i))
+		quotient, _ := qf.hash(key)
+		if quotient%defaultStripes != hotStripe || quotients[quotient] {
+			continue
+		}
+		quotients[quotient] = true
+		qf.Insert(key)
+		keys = append(keys, key)
+	}
+	for i := 0; i < 4; i++ {
+		for _, key := range keys {
+			qf.Exists(key)
+		}
+	}
+	qf.Exists([]byte("anything"))
+
+	stats := qf.StripeStats()
+	if len(stats) != defaultStripes {
+		t.Fatalf("Expected %d stripes, got %d", defaultStripes, len(stats))
+	}
+
+	var total uint64
+	for _, stat := range stats {
+		total += stat.Acquisitions
+	}
+	if stats[hotStripe].Acquisitions < 200 {
+		t.Errorf("Expected at least 200 acquisitions on stripe %d, got %d", hotStripe, stats[hotStripe].Acquisitions)
+	}
+	if others := total - stats[hotStripe].Acquisitions - stats[hotStripe+1].Acquisitions; others > 2 {
+		t.Errorf("Expected at most 2 acquisitions outside stripes %d and %d, got %d", hotStripe, hotStripe+1, others)
+	}
+}
+
+func TestWithStripeCount(t *testing.T) {
+	cases := map[uint]uint{0: 1, 1: 1, 5: 8, 64: 64, 1 << 20: maxStripes}
+	for requested, expected := range cases {
+		qf := NewQuotientFilter(10, WithStripeCount(requested), WithStripeStats())
+		if got := qf.StripeCount(); got != expected {
+			t.Errorf("WithStripeCount(%d): expected %d stripes, got %d", requested, expected, got)
+		}
+		if got := len(qf.StripeStats()); got != int(expected) {
+			t.Errorf("WithStripeCount(%d): expected %d stripe stats, got %d", requested, expected, got)
+		}
+
+		qf.Insert([]byte("key"))
+		if exists, _ := qf.Exists([]byte("key")); !exists {
+			t.Errorf("WithStripeCount(%d): inserted key not found", requested)
+		}
+	}
+
+	if got := NewQuotientFilter(10).StripeCount(); got != defaultStripes {
+		t.Errorf("Expected %d stripes by default, got %d", defaultStripes, got)
+	}
+}
+
+func TestAutoStripeCount(t *testing.T) {
+	const concurrency = 4
+	count := AutoStripeCount(concurrency)
+	if count == 0 || count&(count-1) != 0 {
+		t.Fatalf("Expected a power of two, got %d", count)
+	}
+	if count > 4*concurrency {
+		t.Errorf("Expected at most %d stripes for a concurrency of %d, got %d", 4*concurrency, concurrency, count)
+	}
+}
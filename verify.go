@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Verify checks the structural invariants of the filter and returns the
+// first violation found:
+//
+//   - empty slots hold neither a remainder nor an occupied quotient,
+//   - every occupied quotient owns exactly one run, delimited by a single
+//     run start and run end, and runs appear in quotient order,
+//   - a run never begins before its quotient, and an entry is marked shifted
+//     exactly when it does not sit in its canonical slot,
+//   - the number of stored entries matches Count.
+//
+// It holds every stripe read lock for the duration of the scan, which blocks
+// writers, so prefer running it on small filters or off-peak.
+func (qf *QuotientFilter) Verify() error {
+	qf.rLockAll()
+	defer qf.rUnlockAll()
+
+	size := uint64(len(qf.data))
+
+	origin, found := qf.clusterBoundary()
+	if !found {
+		return fmt.Errorf("every slot is shifted, no cluster has a start")
+	}
+
+	var (
+		entries     int64
+		inCluster   bool
+		inRun       bool
+		runQuotient uint64 // Offset from origin of the quotient owning the current run
+		nextCheck   uint64 // Offset from origin of the next quotient not yet matched to a run
+	)
+
+	// checkUnmatched reports occupied quotients in [nextCheck, until) that
+	// were skipped without being matched to a run.
+	checkUnmatched := func(until uint64) error {
+		for offset := nextCheck; offset < until; offset++ {
+			if slot := (origin + offset) & qf.mask; qf.isOccupied(slot) {
+				return fmt.Errorf("quotient %d is marked occupied but has no run", slot)
+			}
+		}
+		return nil
+	}
+
+	for offset := uint64(0); offset < size; offset++ {
+		slot := (origin + offset) & qf.mask
+
+		if qf.isEmpty(slot) {
+			if inRun {
+				return fmt.Errorf("run of quotient %d is not terminated before empty slot %d", (origin+runQuotient)&qf.mask, slot)
+			}
+			if inCluster {
+				if err := checkUnmatched(offset); err != nil {
+					return err
+				}
+				inCluster = false
+			}
+			if remainder := qf.getRemainder(slot); remainder != 0 {
+				return fmt.Errorf("slot %d is empty but holds remainder %d", slot, remainder)
+			}
+			if qf.isOccupied(slot) {
+				return fmt.Errorf("quotient %d is marked occupied but its slot is empty", slot)
+			}
+			nextCheck = offset + 1
+			continue
+		}
+
+		entries++
+
+		if !inCluster {
+			if qf.isShifted(slot) {
+				return fmt.Errorf("slot %d starts a cluster but is marked shifted", slot)
+			}
+			inCluster = true
+		}
+
+		if inRun {
+			if qf.isRunStart(slot) {
+				return fmt.Errorf("slot %d starts a new run before the run of quotient %d ended", slot, (origin+runQuotient)&qf.mask)
+			}
+			if !qf.isShifted(slot) {
+				return fmt.Errorf("slot %d continues the run of quotient %d but is not marked shifted", slot, (origin+runQuotient)&qf.mask)
+			}
+		} else {
+			if !qf.isRunStart(slot) {
+				return fmt.Errorf("slot %d holds an entry outside of any run", slot)
+			}
+
+			// The run belongs to the first occupied quotient not yet
+			// matched, which cannot lie past the run itself.
+			matched := false
+			for ; nextCheck <= offset; nextCheck++ {
+				if qf.isOccupied((origin + nextCheck) & qf.mask) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Errorf("run starting at slot %d has no occupied quotient at or before it", slot)
+			}
+
+			runQuotient = nextCheck
+			nextCheck++
+			if qf.isShifted(slot) != (runQuotient != offset) {
+				return fmt.Errorf("run of quotient %d starts at slot %d with a wrong shifted bit", (origin+runQuotient)&qf.mask, slot)
+			}
+			inRun = true
+		}
+
+		if qf.isRunEnd(slot) {
+			inRun = false
+		}
+	}
+
+	if inRun {
+		return fmt.Errorf("run of quotient %d is never terminated", (origin+runQuotient)&qf.mask)
+	}
+	if inCluster {
+		if err := checkUnmatched(size); err != nil {
+			return err
+		}
+	}
+
+	if count := qf.count.Load(); entries != count {
+		return fmt.Errorf("filter counts %d items but %d slots hold entries", count, entries)
+	}
+
+	return nil
+}
+
+// RunVerifier calls Verify every interval until ctx is done, logging each
+// violation it finds.
+func (qf *QuotientFilter) RunVerifier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := qf.Verify(); err != nil {
+				log.Printf("Filter invariant violation: %s", err)
+			}
+		}
+	}
+}
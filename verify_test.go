@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func newVerifyTestFilter(t *testing.T) *QuotientFilter {
+	t.Helper()
+
+	qf := NewQuotientFilter(6)
+	for i := 0; i < 48; i++ {
+		if err := qf.Insert([]byte(fmt.Sprintf("item%d", i))); err != nil {
+			t.Fatalf("Failed to insert item%d: %v", i, err)
+		}
+	}
+	for i := 0; i < 48; i += 3 {
+		qf.Remove([]byte(fmt.Sprintf("item%d", i)))
+	}
+	return qf
+}
+
+func TestQuotientFilterVerify(t *testing.T) {
+	t.Run("Healthy filter passes", func(t *testing.T) {
+		qf := newVerifyTestFilter(t)
+		if err := qf.Verify(); err != nil {
+			t.Fatalf("Expected healthy filter to verify, got: %v", err)
+		}
+
+		for i := 0; i < 48; i++ {
+			exists, _ := qf.Exists([]byte(fmt.Sprintf("item%d", i)))
+			if i%3 != 0 && !exists {
+				t.Errorf("item%d should exist in the filter, but doesn't", i)
+			}
+		}
+	})
+
+	corruptions := []struct {
+		name    string
+		corrupt func(qf *QuotientFilter)
+		message string
+	}{
+		{
+			name: "Missing run end",
+			corrupt: func(qf *QuotientFilter) {
+				for slot := uint64(0); slot <= qf.mask; slot++ {
+					if qf.isRunEnd(slot) {
+						qf.clearRunEnd(slot)
+						return
+					}
+				}
+			},
+			message: "run of quotient",
+		},
+		{
+			name: "Orphaned occupied bit",
+			corrupt: func(qf *QuotientFilter) {
+				for slot := uint64(0); slot <= qf.mask; slot++ {
+					if qf.isEmpty(slot) {
+						qf.setOccupied(slot)
+						return
+					}
+				}
+			},
+			message: "marked occupied",
+		},
+		{
+			name: "Remainder left in an empty slot",
+			corrupt: func(qf *QuotientFilter) {
+				for slot := uint64(0); slot <= qf.mask; slot++ {
+					if qf.isEmpty(slot) {
+						qf.setRemainder(slot, 42)
+						return
+					}
+				}
+			},
+			message: "holds remainder 42",
+		},
+		{
+			name: "Stale count",
+			corrupt: func(qf *QuotientFilter) {
+				qf.count.Add(1)
+			},
+			message: "slots hold entries",
+		},
+	}
+
+	for _, tc := range corruptions {
+		t.Run(tc.name, func(t *testing.T) {
+			qf := newVerifyTestFilter(t)
+			tc.corrupt(qf)
+
+			err := qf.Verify()
+			if err == nil {
+				t.Fatal("Expected corrupted filter to fail verification")
+			}
+			if !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("Expected error mentioning %q, got: %v", tc.message, err)
+			}
+		})
+	}
+}